@@ -0,0 +1,4 @@
+// Package api holds gRPC API definition and generated code.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fwset.proto
@@ -0,0 +1,211 @@
+// Package audit holds audit log of firewall set changes.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"log/syslog"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config holds audit log settings.
+type Config struct {
+	Sink string `choice:"none"                      choice:"file"                                choice:"syslog" choice:"slog" default:"none" description:"Audit log sink" env:"SINK" long:"sink"` //nolint:staticcheck
+	File string `default:"/var/log/fwset/audit.log" description:"Audit log file (for sink=file)" env:"FILE"      long:"file"`
+}
+
+const (
+	SinkNone   = "none"
+	SinkFile   = "file"
+	SinkSyslog = "syslog"
+	SinkSlog   = "slog"
+
+	ActionCreate  = "create"
+	ActionDestroy = "destroy"
+	ActionAdd     = "add"
+	ActionRemove  = "remove"
+	ActionReplace = "replace"
+
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+// ErrUnknownSink возвращается для неподдерживаемого типа журнала.
+var ErrUnknownSink = errors.New("unknown audit sink")
+
+// Origin описывает, кто и зачем выполняет изменения.
+type Origin struct {
+	User     string `json:"user"`
+	UID      int    `json:"uid"`
+	SudoUser string `json:"sudo_user,omitempty"` // пользователь, запустивший sudo
+	Command  string `json:"command,omitempty"`   // команда fwset
+	Reason   string `json:"reason,omitempty"`
+	Ticket   string `json:"ticket,omitempty"`
+	Actor    string `json:"actor,omitempty"`     // пользователь клиента API
+	Peer     string `json:"peer,omitempty"`      // адрес клиента API
+	PeerPID  int    `json:"peer_pid,omitempty"`  // процесс клиента API на unix сокете
+	PeerUser string `json:"peer_user,omitempty"` // владелец процесса клиента API на unix сокете
+}
+
+// With возвращает origin, дополненный сведениями о запросе API.
+// Причина и тикет запроса заменяют заданные при запуске.
+func (o Origin) With(req Origin) Origin {
+	o.Actor = req.Actor
+	o.Peer = req.Peer
+	o.PeerPID = req.PeerPID
+	o.PeerUser = req.PeerUser
+
+	if req.Reason != "" {
+		o.Reason = req.Reason
+	}
+
+	if req.Ticket != "" {
+		o.Ticket = req.Ticket
+	}
+
+	return o
+}
+
+// Record - запись журнала.
+type Record struct {
+	Time time.Time `json:"time"`
+	Origin
+	Action   string   `json:"action"`
+	Backend  string   `json:"backend"`
+	Set      string   `json:"set,omitempty"`
+	Networks []string `json:"networks,omitempty"`
+	Timeout  string   `json:"timeout,omitempty"`
+	Comment  string   `json:"comment,omitempty"` // комментарий элементов
+	Result   string   `json:"result"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Sink принимает записи журнала.
+type Sink interface {
+	Write(rec Record) error
+	Close() error
+}
+
+// New возвращает журнал заданного типа, для SinkNone - nil.
+func New(cfg Config) (Sink, error) {
+	switch cfg.Sink {
+	case SinkNone, "":
+		return nil, nil //nolint:nilnil // журнал не ведется
+	case SinkFile:
+		f, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+
+		return &FileSink{f: f}, nil
+	case SinkSyslog:
+		w, err := syslog.New(syslog.LOG_AUTHPRIV|syslog.LOG_NOTICE, "fwset")
+		if err != nil {
+			return nil, err
+		}
+
+		return &SyslogSink{w: w}, nil
+	case SinkSlog:
+		return SlogSink{}, nil
+	default:
+		return nil, ErrUnknownSink
+	}
+}
+
+// CurrentOrigin возвращает сведения о текущем пользователе.
+func CurrentOrigin(command, reason, ticket string) Origin {
+	rv := Origin{
+		UID:      os.Getuid(),
+		SudoUser: os.Getenv("SUDO_USER"),
+		Command:  command,
+		Reason:   reason,
+		Ticket:   ticket,
+	}
+
+	if u, err := user.Current(); err == nil {
+		rv.User = u.Username
+	} else {
+		rv.User = strconv.Itoa(rv.UID)
+	}
+
+	return rv
+}
+
+// FileSink пишет записи в файл в формате JSON lines.
+type FileSink struct {
+	f  *os.File
+	mu sync.Mutex
+}
+
+func (s *FileSink) Write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.f.Write(append(data, '\n'))
+
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// SyslogSink пишет записи в syslog в формате JSON.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+func (s *SyslogSink) Write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.w.Notice(string(data))
+}
+
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
+
+// SlogSink пишет записи в стандартный логгер.
+type SlogSink struct{}
+
+func (SlogSink) Write(rec Record) error {
+	slog.Info("Audit",
+		"user", rec.User,
+		"uid", rec.UID,
+		"sudo_user", rec.SudoUser,
+		"command", rec.Command,
+		"reason", rec.Reason,
+		"ticket", rec.Ticket,
+		"actor", rec.Actor,
+		"peer", rec.Peer,
+		"peer_pid", rec.PeerPID,
+		"peer_user", rec.PeerUser,
+		"action", rec.Action,
+		"backend", rec.Backend,
+		"set", rec.Set,
+		"networks", rec.Networks,
+		"timeout", rec.Timeout,
+		"comment", rec.Comment,
+		"result", rec.Result,
+		"error", rec.Error,
+	)
+
+	return nil
+}
+
+func (SlogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	sink, err := New(Config{Sink: SinkNone})
+	assert.NoError(t, err)
+	assert.Nil(t, sink)
+
+	_, err = New(Config{Sink: "kafka"})
+	assert.ErrorIs(t, err, ErrUnknownSink)
+
+	sink, err = New(Config{Sink: SinkSlog})
+	require.NoError(t, err)
+	assert.NoError(t, sink.Write(Record{Action: ActionCreate}))
+}
+
+func TestFileSink(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+
+	sink, err := New(Config{Sink: SinkFile, File: filename})
+	require.NoError(t, err)
+
+	now := time.Date(2025, 4, 20, 10, 0, 0, 0, time.UTC)
+	origin := Origin{User: "root", Command: "add", Reason: "brute force", Ticket: "SEC-1"}
+	require.NoError(t, sink.Write(Record{Time: now, Origin: origin, Action: ActionAdd, Backend: "nft",
+		Set: "blocked_nets", Networks: []string{"203.0.113.7"}, Result: ResultOK}))
+	require.NoError(t, sink.Write(Record{Time: now, Origin: origin, Action: ActionRemove, Result: ResultError, Error: "no such set"}))
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"time":"2025-04-20T10:00:00Z","user":"root","uid":0,"command":"add","reason":"brute force",
+		"ticket":"SEC-1","action":"add","backend":"nft","set":"blocked_nets","networks":["203.0.113.7"],"result":"ok"}`, lines[0])
+
+	var rec Record
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, "no such set", rec.Error)
+	assert.Equal(t, "brute force", rec.Reason)
+
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestCurrentOrigin(t *testing.T) {
+	t.Setenv("SUDO_USER", "alice")
+
+	origin := CurrentOrigin("del", "false positive", "")
+	assert.Equal(t, os.Getuid(), origin.UID)
+	assert.NotEmpty(t, origin.User)
+	assert.Equal(t, "alice", origin.SudoUser)
+	assert.Equal(t, "del", origin.Command)
+}
+
+func TestOriginWith(t *testing.T) {
+	base := Origin{User: "root", Command: "serve", Reason: "daemon", Ticket: "OPS-1"}
+
+	origin := base.With(Origin{Actor: "alice", Reason: "scan", PeerPID: 42, PeerUser: "alice"})
+	assert.Equal(t, Origin{User: "root", Command: "serve", Reason: "scan", Ticket: "OPS-1", Actor: "alice", PeerPID: 42, PeerUser: "alice"}, origin)
+	assert.Equal(t, base, base.With(Origin{}))
+}
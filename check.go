@@ -0,0 +1,207 @@
+package fwset
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/utils"
+)
+
+const (
+	CheckAccept = "accept"
+	CheckDrop   = "drop"
+	CheckReject = "reject"
+	CheckJump   = "jump"
+	CheckNone   = "none" // адрес не входит в сеты
+)
+
+// Check описывает результат проверки адреса по сетам.
+// Status - вердикт правила сета с наибольшим приоритетом, в который входит адрес.
+type Check struct {
+	Address string  `json:"address"           yaml:"address"`
+	Status  string  `json:"status"            yaml:"status"`
+	Matches []Match `json:"matches,omitempty" yaml:"matches,omitempty"`
+}
+
+// Match описывает элемент сета, в который входит адрес, и условия правила сета.
+type Match struct {
+	Set      string   `json:"set"                yaml:"set"`
+	Verdict  string   `json:"verdict"            yaml:"verdict"`
+	Jump     string   `json:"jump,omitempty"     yaml:"jump,omitempty"`
+	Match    string   `json:"match"              yaml:"match"`
+	Protocol string   `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Ports    []uint16 `json:"ports,omitempty"    yaml:"ports,omitempty"`
+	Network  string   `json:"network"            yaml:"network"`
+	Expires  string   `json:"expires,omitempty"  yaml:"expires,omitempty"`
+	Comment  string   `json:"comment,omitempty"  yaml:"comment,omitempty"`
+}
+
+// Check проверяет, в элементы каких сетов входят адреса.
+// Сеты просматриваются в порядке приоритета, статус проверки - вердикт первого совпадения.
+// Правило сета может срабатывать только для части пакетов (протокол, порты, направление),
+// эти условия выводятся в совпадении.
+func (fw *Firewall) Check(addresses []string) ([]Check, error) {
+	ips := make([]netip.Addr, len(addresses))
+
+	for i, address := range addresses {
+		ip, err := netip.ParseAddr(address)
+		if err != nil {
+			return nil, err
+		}
+
+		ips[i] = ip.Unmap()
+	}
+
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	// элементы и их диапазоны читаются один раз для всех адресов
+	elements := make([][]config.Element, len(specs))
+	ranges := make([][]utils.IPRange, len(specs))
+
+	for i, spec := range specs {
+		if elements[i], err = fw.ListElements(spec.Name); err != nil {
+			return nil, err
+		}
+
+		if ranges[i], err = elementRanges(elements[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	rv := make([]Check, len(ips))
+
+	for i, ip := range ips {
+		check := Check{Address: ip.String(), Status: CheckNone}
+		addr := utils.IPRange{Start: ip, End: ip}
+
+		for j, spec := range specs {
+			for k, elem := range elements[j] {
+				if !ranges[j][k].Contains(addr) {
+					continue
+				}
+
+				if check.Status == CheckNone {
+					check.Status = spec.Verdict
+				}
+
+				m := Match{
+					Set:      spec.Name,
+					Verdict:  spec.Verdict,
+					Match:    spec.Match,
+					Protocol: spec.Protocol,
+					Ports:    spec.Ports,
+					Network:  elem.Network,
+					Comment:  elem.Comment,
+				}
+				if spec.Verdict == config.VerdictJump {
+					m.Jump = spec.Jump
+				}
+
+				if elem.Expires > 0 {
+					m.Expires = elem.Expires.Round(time.Second).String()
+				}
+
+				check.Matches = append(check.Matches, m)
+			}
+		}
+
+		rv[i] = check
+	}
+
+	return rv, nil
+}
+
+// rule возвращает вердикт и условия правила совпадения, например "drop, dst, tcp dport 22,80".
+func (m Match) rule() string {
+	parts := []string{m.Verdict}
+	if m.Jump != "" {
+		parts[0] += " " + m.Jump
+	}
+
+	if m.Match == config.MatchDst {
+		parts = append(parts, m.Match)
+	}
+
+	if m.Protocol != "" {
+		proto := m.Protocol
+
+		if len(m.Ports) > 0 {
+			ports := make([]string, len(m.Ports))
+			for i, port := range m.Ports {
+				ports[i] = strconv.Itoa(int(port))
+			}
+
+			proto += " dport " + strings.Join(ports, ",")
+		}
+
+		parts = append(parts, proto)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// WriteChecks выводит результаты проверки адресов в заданном формате.
+func WriteChecks(w io.Writer, format string, checks []Check) error {
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(checks)
+	case OutputYAML:
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+
+		return enc.Encode(checks)
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"address", "status", "set", "rule", "network", "expires", "comment"})
+
+		for _, check := range checks {
+			if len(check.Matches) == 0 {
+				_ = cw.Write([]string{check.Address, check.Status, "", "", "", "", ""})
+			}
+
+			for _, m := range check.Matches {
+				_ = cw.Write([]string{check.Address, check.Status, m.Set, m.rule(), m.Network, m.Expires, m.Comment})
+			}
+		}
+
+		cw.Flush()
+
+		return cw.Error()
+	case OutputPlain:
+		for _, check := range checks {
+			fmt.Fprintln(w, check.Address, check.Status)
+
+			for _, m := range check.Matches {
+				line := fmt.Sprintf("  %s (%s): %s", m.Set, m.rule(), m.Network)
+				if m.Expires != "" {
+					line += " (expires in " + m.Expires + ")"
+				}
+
+				if m.Comment != "" {
+					line += " # " + m.Comment
+				}
+
+				fmt.Fprintln(w, line)
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownOutput, format)
+	}
+}
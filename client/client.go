@@ -0,0 +1,135 @@
+// Package client holds Go client for fwset gRPC API.
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/LeKovr/fwset/api"
+)
+
+// Client вызывает методы gRPC API fwset.
+type Client struct {
+	conn *grpc.ClientConn
+	api  api.FWSetClient
+}
+
+// New возвращает клиента для адреса вида "unix:///run/fwset-grpc.sock" или "host:port".
+// Если задан token, он передается в заголовке authorization.
+func New(target, token string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenAuth(token)))
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn, api: api.NewFWSetClient(conn)}, nil
+}
+
+// Close закрывает соединение.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// CreateSets создает таблицу, сеты и правила.
+func (c *Client) CreateSets(ctx context.Context) error {
+	_, err := c.api.CreateSets(ctx, &api.CreateSetsRequest{})
+
+	return err
+}
+
+// DestroySets удаляет сеты и правила.
+func (c *Client) DestroySets(ctx context.Context) error {
+	_, err := c.api.DestroySets(ctx, &api.DestroySetsRequest{})
+
+	return err
+}
+
+// AddNetworks добавляет сети в сет accept или drop, timeout 0 - без ограничения времени жизни.
+func (c *Client) AddNetworks(ctx context.Context, accept bool, networks []string, timeout time.Duration, comment string) error {
+	return c.addNetworks(ctx, &api.AddNetworksRequest{Accept: accept, Networks: networks, Comment: comment}, timeout)
+}
+
+// AddToSet добавляет сети в сет с заданным именем.
+func (c *Client) AddToSet(ctx context.Context, set string, networks []string, timeout time.Duration, comment string) error {
+	return c.addNetworks(ctx, &api.AddNetworksRequest{Set: set, Networks: networks, Comment: comment}, timeout)
+}
+
+func (c *Client) addNetworks(ctx context.Context, req *api.AddNetworksRequest, timeout time.Duration) error {
+	if timeout > 0 {
+		req.Timeout = durationpb.New(timeout)
+	}
+
+	_, err := c.api.AddNetworks(ctx, req)
+
+	return err
+}
+
+// RemoveNetworks удаляет сети из сета accept или drop.
+func (c *Client) RemoveNetworks(ctx context.Context, accept bool, networks []string) error {
+	_, err := c.api.RemoveNetworks(ctx, &api.RemoveNetworksRequest{Accept: accept, Networks: networks})
+
+	return err
+}
+
+// RemoveFromSet удаляет сети из сета с заданным именем.
+func (c *Client) RemoveFromSet(ctx context.Context, set string, networks []string) error {
+	_, err := c.api.RemoveNetworks(ctx, &api.RemoveNetworksRequest{Set: set, Networks: networks})
+
+	return err
+}
+
+// ListNetworks возвращает содержимое сетов.
+func (c *Client) ListNetworks(ctx context.Context) ([]*api.Set, error) {
+	resp, err := c.api.ListNetworks(ctx, &api.ListNetworksRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetSets(), nil
+}
+
+// WatchChanges вызывает fn для каждого изменения до отмены ctx или ошибки fn.
+func (c *Client) WatchChanges(ctx context.Context, fn func(*api.Change) error) error {
+	stream, err := c.api.WatchChanges(ctx, &api.WatchChangesRequest{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		change, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err = fn(change); err != nil {
+			return err
+		}
+	}
+}
+
+// tokenAuth передает токен в каждом запросе.
+type tokenAuth string
+
+func (t tokenAuth) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity разрешает передачу токена без TLS (unix сокет или доверенная сеть).
+func (t tokenAuth) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/LeKovr/fwset"
+	"github.com/LeKovr/fwset/api"
+	"github.com/LeKovr/fwset/audit"
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/server"
+)
+
+type FakeFirewall struct {
+	Changes []config.Change
+}
+
+func (f *FakeFirewall) Create() error  { return nil }
+func (f *FakeFirewall) Destroy() error { return nil }
+
+func (f *FakeFirewall) AddChange(change config.Change) error {
+	f.Changes = append(f.Changes, change)
+	return nil
+}
+
+func (f *FakeFirewall) RemoveChange(change config.Change) error {
+	return f.AddChange(change)
+}
+
+func (f *FakeFirewall) Origin() audit.Origin   { return audit.Origin{} }
+func (f *FakeFirewall) SetOrigin(audit.Origin) {}
+
+func (f *FakeFirewall) Sets() ([]fwset.Set, error) {
+	return []fwset.Set{{
+		Name:     "blocked_nets",
+		Verdict:  fwset.VerdictDrop,
+		Elements: []fwset.Element{{Network: "10.0.0.1", Type: fwset.ElementHost, Expires: "14m59s"}},
+	}}, nil
+}
+
+func newClient(t *testing.T, fw server.Firewall, token, clientToken string) *Client {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	srv := server.NewGRPC(server.NewHub(fw), token)
+
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	cli, err := New("passthrough:///bufnet", clientToken, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { cli.Close() })
+
+	return cli
+}
+
+func TestClient(t *testing.T) {
+	fw := &FakeFirewall{}
+	cli := newClient(t, fw, "", "")
+	ctx := context.Background()
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	events := make(chan *api.Change, 10)
+	done := make(chan error)
+
+	go func() {
+		done <- cli.WatchChanges(watchCtx, func(change *api.Change) error {
+			events <- change
+			return nil
+		})
+	}()
+
+	// подписка оформляется асинхронно, повторяем создание до получения события
+	require.Eventually(t, func() bool {
+		assert.NoError(t, cli.CreateSets(ctx))
+		select {
+		case event := <-events:
+			return event.GetAction() == api.Change_ACTION_CREATE
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 3*time.Second, time.Millisecond)
+
+	require.NoError(t, cli.AddNetworks(ctx, false, []string{"10.0.0.1"}, 15*time.Minute, "abuse ticket 123"))
+	require.NoError(t, cli.RemoveNetworks(ctx, true, []string{"10.0.0.0/24"}))
+	require.NoError(t, cli.AddToSet(ctx, "scanners", []string{"192.0.2.1"}, 0, ""))
+	require.NoError(t, cli.RemoveFromSet(ctx, "scanners", []string{"192.0.2.1"}))
+
+	event := <-events
+	assert.Equal(t, api.Change_ACTION_ADD, event.GetAction())
+	assert.Equal(t, []string{"10.0.0.1"}, event.GetNetworks())
+	assert.Equal(t, 15*time.Minute, event.GetTimeout().AsDuration())
+	assert.Equal(t, "abuse ticket 123", event.GetComment())
+
+	event = <-events
+	assert.Equal(t, api.Change_ACTION_REMOVE, event.GetAction())
+	assert.True(t, event.GetAccept())
+
+	event = <-events
+	assert.Equal(t, api.Change_ACTION_ADD, event.GetAction())
+	assert.Equal(t, "scanners", event.GetSet())
+
+	event = <-events
+	assert.Equal(t, api.Change_ACTION_REMOVE, event.GetAction())
+	assert.Equal(t, "scanners", event.GetSet())
+
+	assert.Equal(t, []config.Change{
+		{Add: true, Networks: []string{"10.0.0.1"}, Timeout: 15 * time.Minute, Comment: "abuse ticket 123"},
+		{Accept: true, Networks: []string{"10.0.0.0/24"}},
+		{Set: "scanners", Add: true, Networks: []string{"192.0.2.1"}},
+		{Set: "scanners", Networks: []string{"192.0.2.1"}},
+	}, fw.Changes)
+
+	sets, err := cli.ListNetworks(ctx)
+	require.NoError(t, err)
+	require.Len(t, sets, 1)
+	assert.Equal(t, "blocked_nets", sets[0].GetName())
+	assert.Equal(t, 14*time.Minute+59*time.Second, sets[0].GetElements()[0].GetExpires().AsDuration())
+
+	err = cli.AddNetworks(ctx, false, []string{"10.0.0.256"}, 0, "")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = cli.AddNetworks(ctx, false, nil, 0, "")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	require.NoError(t, cli.DestroySets(ctx))
+	assert.Equal(t, api.Change_ACTION_DESTROY, (<-events).GetAction())
+
+	cancel()
+	assert.Equal(t, codes.Canceled, status.Code(<-done))
+}
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+
+	err := newClient(t, &FakeFirewall{}, "secret", "").CreateSets(ctx)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	err = newClient(t, &FakeFirewall{}, "secret", "secret").CreateSets(ctx)
+	assert.NoError(t, err)
+
+	err = newClient(t, &FakeFirewall{}, "secret", "").WatchChanges(ctx, func(*api.Change) error { return nil })
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -6,25 +6,44 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"time"
 
 	"github.com/LeKovr/go-kit/config"
 	"github.com/LeKovr/go-kit/slogger"
 	"github.com/LeKovr/go-kit/ver"
 
 	"github.com/LeKovr/fwset"
+	"github.com/LeKovr/fwset/audit"
+	fwconfig "github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/feeds"
+	"github.com/LeKovr/fwset/server"
+	"github.com/LeKovr/fwset/utils"
 )
 
 // Config holds all config vars.
 type Config struct {
 	Command struct {
-		Name string   `choice:"create"                                            choice:"list"            choice:"add" choice:"del" choice:"destroy" description:"Команда"        positional-arg-name:"COMMAND"` //nolint:staticcheck
-		IPs  []string `description:"IP адрес (для команд add, del)"               positional-arg-name:"IP"`
+		Name string   `choice:"create"                                                                choice:"list"            choice:"add" choice:"del" choice:"destroy" choice:"apply" choice:"import" choice:"replace" choice:"export" choice:"check" choice:"lint" choice:"serve" description:"Команда" positional-arg-name:"COMMAND"` //nolint:staticcheck
+		IPs  []string `description:"IP адрес (для команд add, del, check) или файл фида (для import)" positional-arg-name:"IP"`
 	} `positional-args:"true"`
-	IsAccept bool `description:"Use Accept instead of Drop" env:"ACCEPT" long:"accept"`
+	IsAccept  bool          `description:"Use Accept instead of Drop"                                     env:"ACCEPT"      long:"accept"`
+	SetName   string        `description:"Set name (for add, del, import, replace; default: by --accept)" env:"SET"         long:"set"`
+	StateFile string        `description:"State file, - for stdio (for apply, export; feed for replace)"  env:"STATE"       long:"file"      short:"f"`
+	FromFile  string        `description:"Read networks from file, - for stdin (for add, del, check)"     env:"FROM_FILE"   long:"from_file"`
+	Format    string        `choice:"netset"                                                              choice:"spamhaus" choice:"p2p"     default:"netset" description:"Feed format (for import, replace)" env:"FORMAT"                                                long:"format"`
+	Output    string        `choice:"plain"                                                               choice:"json"     choice:"yaml"    choice:"csv"     description:"Output format (for list, check, lint: default plain; export: default yaml)" env:"OUTPUT"  long:"output"`
+	Timeout   time.Duration `description:"Element timeout, e.g. 15m (for add, import, replace)"           env:"TIMEOUT"     long:"timeout"`
+	Comment   string        `description:"Element comment (for add, import, replace)"                     env:"COMMENT"     long:"comment"`
+	Reason    string        `description:"Reason of change (for audit log)"                               env:"REASON"      long:"reason"`
+	Ticket    string        `description:"Ticket of change (for audit log)"                               env:"TICKET"      long:"ticket"`
 
 	fwset.Config
-	Logger slogger.Config `env-namespace:"LOG" group:"Logging Options" namespace:"log"`
+	Server server.Config  `env-namespace:"SRV"   group:"Server Options"  namespace:"srv"`
+	Audit  audit.Config   `env-namespace:"AUDIT" group:"Audit Options"   namespace:"audit"`
+	Logger slogger.Config `env-namespace:"LOG"   group:"Logging Options" namespace:"log"`
 
 	config.EnableShowVersion
 	config.EnableConfigDefGen
@@ -43,11 +62,14 @@ var (
 	repo = "repo.git"
 
 	ErrNoRequiredIPs  = errors.New("network address required")
+	ErrNoRequiredFile = errors.New("state file required")
+	ErrNoRequiredFeed = errors.New("feed file required")
 	ErrUnknownCommand = errors.New("unknown command")
 )
 
 // Run app and exit via given exitFunc.
-func Run(_ context.Context, exitFunc func(code int)) {
+// Cancelling ctx stops the serve command.
+func Run(ctx context.Context, exitFunc func(code int)) {
 	config.SetApplicationVersion(application, version)
 	// Load config
 	var cfg Config
@@ -70,7 +92,8 @@ func Run(_ context.Context, exitFunc func(code int)) {
 		return
 	}
 
-	fmt.Println(application, version)
+	// stdout оставляем для вывода команд
+	fmt.Fprintln(os.Stderr, application, version)
 
 	go ver.Check(repo, version)
 
@@ -81,12 +104,37 @@ func Run(_ context.Context, exitFunc func(code int)) {
 		return
 	}
 
-	err = run(cfg, fw)
+	var sink audit.Sink
+
+	// в режиме dry run изменений нет, журнал не пишется
+	if !cfg.DryRun {
+		if sink, err = audit.New(cfg.Audit); err != nil {
+			return
+		}
+	}
+
+	if sink != nil {
+		defer sink.Close()
+		fw.SetAudit(sink, audit.CurrentOrigin(cfg.Command.Name, cfg.Reason, cfg.Ticket))
+	}
+
+	err = run(ctx, cfg, fw)
+
+	if err == nil && cfg.DryRun {
+		fmt.Fprintln(os.Stderr, "Dry run, firewall not changed")
+	}
 }
 
-func run(cfg Config, fw *fwset.Firewall) error {
+func run(ctx context.Context, cfg Config, fw *fwset.Firewall) error {
 	var err error
 
+	// опечатка в --set иначе приводит к ошибке фаервола
+	if cfg.SetName != "" {
+		if _, err = fw.SetSpec(cfg.SetName); err != nil {
+			return err
+		}
+	}
+
 	switch cfg.Command.Name {
 	case "create":
 		if err = fw.Create(); err == nil {
@@ -99,52 +147,283 @@ func run(cfg Config, fw *fwset.Firewall) error {
 		}
 
 	case "add":
-		if len(cfg.Command.IPs) < 1 {
-			return ErrNoRequiredIPs
+		var networks []string
+
+		if networks, err = commandNetworks(cfg); err != nil {
+			return err
 		}
 
-		if err = fw.Add(cfg.IsAccept, cfg.Command.IPs); err != nil {
+		if err = fw.AddChange(addChange(cfg, fw, networks)); err != nil {
 			return err
 		}
 
 		fmt.Println("Network added")
 	case "del":
-		if len(cfg.Command.IPs) < 1 {
-			return ErrNoRequiredIPs
+		var networks []string
+
+		if networks, err = commandNetworks(cfg); err != nil {
+			return err
 		}
 
-		if err = fw.Remove(cfg.IsAccept, cfg.Command.IPs); err != nil {
+		if err = fw.Remove(setName(cfg, fw), networks); err != nil {
 			return err
 		}
 
 		fmt.Println("Network removed")
 	case "list":
+		var sets []fwset.Set
+
+		if sets, err = fw.Sets(); err != nil {
+			return err
+		}
+
+		return fwset.WriteSets(os.Stdout, outputFormat(cfg.Output), sets)
+	case "export":
+		if cfg.StateFile == "" {
+			return ErrNoRequiredFile
+		}
+
+		var sets []fwset.Set
+
+		if sets, err = fw.Sets(); err != nil {
+			return err
+		}
+
+		if err = export(cfg.StateFile, cfg.Output, sets); err == nil && cfg.StateFile != "-" {
+			fmt.Println("Sets exported")
+		}
+	case "apply":
+		if cfg.StateFile == "" {
+			return ErrNoRequiredFile
+		}
+
+		var changes []fwconfig.Change
+
+		if changes, err = plan(cfg.StateFile, fw); err != nil {
+			return err
+		}
+
+		printChanges(changes)
+
+		if len(changes) == 0 {
+			return nil
+		}
+
+		if err = fw.Apply(changes); err == nil {
+			fmt.Println("State applied")
+		}
+	case "import":
 		var networks []string
 
-		networks, err = fw.List(true)
-		if err != nil {
+		if networks, err = importFeeds(cfg.Format, cfg.Command.IPs); err != nil {
 			return err
 		}
 
-		fmt.Println("Allowed networks:")
+		if err = fw.AddChange(addChange(cfg, fw, networks)); err == nil {
+			fmt.Println("Networks imported:", len(networks))
+		}
+	case "replace":
+		if cfg.StateFile == "" {
+			return ErrNoRequiredFeed
+		}
 
-		for _, network := range networks {
-			fmt.Println(network)
+		var networks []string
+
+		if networks, err = importFeeds(cfg.Format, []string{cfg.StateFile}); err != nil {
+			return err
 		}
 
-		networks, err = fw.List(false)
-		if err != nil {
+		var added, removed int
+
+		if added, removed, err = fw.Replace(addChange(cfg, fw, networks)); err == nil {
+			fmt.Printf("Set %s replaced: %d added, %d removed\n", setName(cfg, fw), added, removed)
+		}
+	case "check":
+		var addresses []string
+
+		if addresses, err = commandNetworks(cfg); err != nil {
 			return err
 		}
 
-		fmt.Println("Blocked networks:")
+		var checks []fwset.Check
 
-		for _, network := range networks {
-			fmt.Println(network)
+		if checks, err = fw.Check(addresses); err != nil {
+			return err
 		}
+
+		return fwset.WriteChecks(os.Stdout, outputFormat(cfg.Output), checks)
+	case "lint":
+		var findings []fwset.Finding
+
+		if findings, err = fw.Lint(); err != nil {
+			return err
+		}
+
+		if err = fwset.WriteFindings(os.Stdout, outputFormat(cfg.Output), findings); err != nil {
+			return err
+		}
+
+		conflicts := 0
+
+		for _, f := range findings {
+			if f.Kind == fwset.FindingConflict {
+				conflicts++
+			}
+		}
+
+		if conflicts > 0 {
+			return fmt.Errorf("%w: %d conflicts found", fwset.ErrConflict, conflicts)
+		}
+	case "serve":
+		return server.Run(ctx, cfg.Server, fw)
 	default:
 		return ErrUnknownCommand
 	}
 
 	return err
 }
+
+// addChange возвращает изменение для добавления сетей с учетом --timeout и --comment.
+func addChange(cfg Config, fw *fwset.Firewall, networks []string) fwconfig.Change {
+	return fwconfig.Change{Set: setName(cfg, fw), Add: true, Networks: networks, Timeout: cfg.Timeout, Comment: cfg.Comment}
+}
+
+// setName возвращает имя сета из --set или сет accept/drop по --accept.
+func setName(cfg Config, fw *fwset.Firewall) string {
+	if cfg.SetName != "" {
+		return cfg.SetName
+	}
+
+	return fw.SetName(cfg.IsAccept)
+}
+
+// commandNetworks возвращает сети из аргументов и файла --from_file.
+func commandNetworks(cfg Config) ([]string, error) {
+	networks := cfg.Command.IPs
+
+	if cfg.FromFile != "" {
+		f, err := openFile(cfg.FromFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		nets, err := utils.ReadNetworks(f)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, nets...)
+	}
+
+	if len(networks) < 1 {
+		return nil, ErrNoRequiredIPs
+	}
+
+	return networks, nil
+}
+
+// importFeeds возвращает сети из файлов фидов в заданном формате.
+func importFeeds(format string, filenames []string) ([]string, error) {
+	if len(filenames) < 1 {
+		return nil, ErrNoRequiredFeed
+	}
+
+	var networks []string
+
+	for _, filename := range filenames {
+		f, err := openFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		nets, err := feeds.Read(format, f)
+		f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		networks = append(networks, nets...)
+	}
+
+	return networks, nil
+}
+
+// export записывает сеты в файл, для имени "-" - в stdout.
+func export(filename, format string, sets []fwset.Set) error {
+	if filename == "-" {
+		return fwset.ExportSets(os.Stdout, format, sets)
+	}
+
+	if err := fwset.ExportSets(io.Discard, format, nil); err != nil {
+		return err // не создаем файл для неподдерживаемого формата
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err = fwset.ExportSets(f, format, sets); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// outputFormat возвращает формат вывода команд list, check и lint, по умолчанию plain.
+func outputFormat(format string) string {
+	if format == "" {
+		return fwset.OutputPlain
+	}
+
+	return format
+}
+
+// openFile открывает файл на чтение, для имени "-" возвращает stdin.
+func openFile(filename string) (io.ReadCloser, error) {
+	if filename == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	return os.Open(filename)
+}
+
+// plan читает желаемое состояние из файла и возвращает изменения для его достижения.
+func plan(filename string, fw *fwset.Firewall) ([]fwconfig.Change, error) {
+	f, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	state, err := fwset.ReadState(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return fw.Plan(*state)
+}
+
+// printChanges выводит изменения в виде "+ blocked_nets 10.0.0.0/8".
+func printChanges(changes []fwconfig.Change) {
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+
+		return
+	}
+
+	for _, change := range changes {
+		op := "-"
+		if change.Add {
+			op = "+"
+		}
+
+		for _, network := range change.Networks {
+			fmt.Println(op, change.Set, network)
+		}
+	}
+}
@@ -1,9 +1,375 @@
 // package config hold common for any fw settings.
 package config
 
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetSuffixIPv6 добавляется к имени сета для хранения IPv6 сетей.
+const SetSuffixIPv6 = "6"
+
 type Config struct {
-	TableName     string `default:"myfirewall"   description:"Table name"      env:"TABLE"      long:"table"`
-	ChainName     string `default:"input"        description:"Chain name"      env:"CHAIN"      long:"chain"`
-	SetNameDrop   string `default:"blocked_nets" description:"Drop set name"   env:"SET_DROP"   long:"set_drop"`
-	SetNameAccept string `default:"allowed_nets" description:"Accept set name" env:"SET_ACCEPT" long:"set_accept"`
+	TableName     string `default:"myfirewall"                                             description:"Table name"                                                 env:"TABLE"        long:"table"`
+	ChainName     string `default:"input"                                                  description:"Chain name"                                                 env:"CHAIN"        long:"chain"`
+	ChainHook     string `choice:"input"                                                   choice:"forward"                                                         choice:"output"    choice:"prerouting"              choice:"postrouting" choice:"ingress" default:"input" description:"Chain hook (nft)" env:"HOOK" long:"hook"` //nolint:staticcheck
+	ChainDevice   string `description:"Network device (nft, for hook ingress)"             env:"DEVICE"                                                             long:"device"`
+	ChainPriority int    `default:"0"                                                      description:"Chain priority (nft), 0 - filter"                           env:"PRIORITY"     long:"priority"`
+	ChainPolicy   string `choice:"accept"                                                  choice:"drop"                                                            default:"accept"   description:"Chain policy (nft)" env:"POLICY"         long:"policy"` //nolint:staticcheck
+	Attach        bool   `description:"Use existing table and chain (nft)"                 env:"ATTACH"                                                             long:"attach"`
+	Position      string `description:"Rule position: last, first or rule handle to insert before (default: last for nft, first for ipset)" env:"POSITION" long:"position"`
+	NoRules       bool   `description:"Create sets without rules"                          env:"NO_RULES"                                                           long:"no_rules"`
+	SetNameDrop   string `default:"blocked_nets"                                           description:"Drop set name"                                              env:"SET_DROP"     long:"set_drop"`
+	SetNameAccept string `default:"allowed_nets"                                           description:"Accept set name"                                            env:"SET_ACCEPT"   long:"set_accept"`
+	DryRun        bool   `description:"Print firewall operations instead of applying them" env:"DRY_RUN"                                                            long:"dry_run"`
+	ListRanges    bool   `description:"Show adjacent ipset entries as ranges"              env:"LIST_RANGES"                                                        long:"list_ranges"`
+
+	RuleAccept Rule `env-namespace:"ACCEPT" group:"Accept Rule Options" namespace:"accept"`
+	RuleDrop   Rule `env-namespace:"DROP"   group:"Drop Rule Options"   namespace:"drop"`
+
+	// Sets - дополнительные сеты
+	Sets []SetSpec `no-flag:"true"`
+}
+
+// Rule описывает условие и действие правила, проверяющего адрес по сету.
+type Rule struct {
+	Match      string   `choice:"src"                                                                    choice:"dst"              default:"src"     description:"Match packet source or destination address" env:"MATCH"                                       long:"match"               yaml:"match,omitempty"`    //nolint:staticcheck
+	Protocol   string   `choice:"tcp"                                                                    choice:"udp"              choice:"icmp"     description:"Match protocol (default: any)"              env:"PROTOCOL"                                    long:"protocol"            yaml:"protocol,omitempty"` //nolint:staticcheck
+	Ports      []uint16 `description:"Match destination port (for protocol tcp or udp), can be repeated" env:"PORTS"               env-delim:","     long:"port"                                              yaml:"ports,omitempty"`
+	Verdict    string   `choice:"accept"                                                                 choice:"drop"             choice:"reject"   choice:"jump"                                            description:"Rule verdict (default: set verdict)" env:"VERDICT"              long:"verdict"                                 yaml:"verdict,omitempty"`                                                 //nolint:staticcheck
+	RejectWith string   `choice:"port-unreachable"                                                       choice:"host-unreachable" choice:"no-route" choice:"admin-prohibited"                                choice:"tcp-reset"                                default:"port-unreachable" description:"Reject type (for verdict=reject)" env:"REJECT_WITH"        long:"reject_with" yaml:"reject_with,omitempty"` //nolint:staticcheck
+	Jump       string   `description:"Chain name (for verdict=jump)"                                     env:"JUMP"                long:"jump"       yaml:"jump,omitempty"`
+	NoCounter  bool     `description:"Do not count packets"                                              env:"NO_COUNTER"          long:"no_counter" yaml:"no_counter,omitempty"`
+	NoLog      bool     `description:"Do not log packets"                                                env:"NO_LOG"              long:"no_log"     yaml:"no_log,omitempty"`
+	LogPrefix  string   `description:"Log prefix"                                                        env:"LOG_PREFIX"          long:"log_prefix" yaml:"log_prefix,omitempty"`
+	LogLevel   string   `choice:"emerg"                                                                  choice:"alert"            choice:"crit"     choice:"err"                                             choice:"warn"                                     choice:"notice"            choice:"info"                                  choice:"debug"           default:"warn"     description:"Log level"      env:"LOG_LEVEL" long:"log_level" yaml:"log_level,omitempty"` //nolint:staticcheck
+	LogRate    string   `description:"Log rate limit, e.g. 10/minute (default: unlimited)"               env:"LOG_RATE"            long:"log_rate"   yaml:"log_rate,omitempty"`
+}
+
+// SetSpec описывает сет и правило для него.
+type SetSpec struct {
+	Name     string `yaml:"name"`
+	Rule     `yaml:",inline"`
+	Priority int `yaml:"priority,omitempty"` // правила сетов с меньшим значением проверяются раньше
+}
+
+const (
+	PositionLast  = "last"
+	PositionFirst = "first"
+
+	HookInput       = "input"
+	HookForward     = "forward"
+	HookOutput      = "output"
+	HookPrerouting  = "prerouting"
+	HookPostrouting = "postrouting"
+	HookIngress     = "ingress"
+
+	MatchSrc = "src"
+	MatchDst = "dst"
+
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+	ProtocolICMP = "icmp"
+
+	VerdictAccept = "accept"
+	VerdictDrop   = "drop"
+	VerdictReject = "reject"
+	VerdictJump   = "jump"
+
+	RejectPortUnreachable = "port-unreachable"
+	RejectHostUnreachable = "host-unreachable"
+	RejectNoRoute         = "no-route"
+	RejectAdminProhibited = "admin-prohibited"
+	RejectTCPReset        = "tcp-reset"
+)
+
+var (
+	// LogLevels - уровни логирования в порядке syslog.
+	LogLevels = []string{"emerg", "alert", "crit", "err", "warn", "notice", "info", "debug"}
+
+	// RateUnits - единицы ограничения частоты в секундах.
+	RateUnits = map[string]uint64{"second": 1, "minute": 60, "hour": 60 * 60, "day": 24 * 60 * 60}
+
+	ErrNoJumpChain     = errors.New("chain required for verdict jump")
+	ErrInvalidRate     = errors.New("invalid rate, want N/second|minute|hour|day")
+	ErrNoSetName       = errors.New("set name required")
+	ErrDuplicateSet    = errors.New("duplicate set name")
+	ErrUnknownSet      = errors.New("unknown set")
+	ErrUnknownVerdict  = errors.New("unknown verdict")
+	ErrUnknownMatch    = errors.New("unknown match, want src or dst")
+	ErrUnknownProtocol = errors.New("unknown protocol, want tcp, udp or icmp")
+	ErrPortsNoProtocol = errors.New("ports require protocol tcp or udp")
+	ErrResetNotTCP     = errors.New("tcp-reset requires protocol tcp")
+	ErrUnknownHook     = errors.New("unknown chain hook")
+	ErrNoDevice        = errors.New("device required for hook ingress")
+	ErrDeviceHook      = errors.New("device is used only with hook ingress")
+	ErrUnknownPolicy   = errors.New("unknown chain policy, want accept or drop")
+	ErrInvalidPosition = errors.New("invalid position, want last, first or rule handle")
+	ErrRejectIngress   = errors.New("verdict reject is not supported in hook ingress")
+	ErrNFTOnly         = errors.New("options are supported only by nft")
+)
+
+// ValidateChain проверяет настройки базовой цепочки.
+func (cfg Config) ValidateChain() error {
+	switch cfg.ChainHook {
+	case "", HookInput, HookForward, HookOutput, HookPrerouting, HookPostrouting:
+		if cfg.ChainDevice != "" {
+			return ErrDeviceHook
+		}
+	case HookIngress:
+		if cfg.ChainDevice == "" {
+			return ErrNoDevice
+		}
+
+		if err := cfg.validateIngress(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownHook, cfg.ChainHook)
+	}
+
+	switch cfg.ChainPolicy {
+	case "", VerdictAccept, VerdictDrop:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownPolicy, cfg.ChainPolicy)
+	}
+
+	_, err := cfg.PositionHandle()
+
+	return err
+}
+
+// validateIngress проверяет, что правила сетов можно добавить в цепочку с хуком ingress:
+// ядро принимает reject только в хуках input, forward и output и отклоняет транзакцию целиком.
+// С Attach цепочка уже существует и хук из настроек не используется.
+func (cfg Config) validateIngress() error {
+	if cfg.Attach || cfg.NoRules {
+		return nil
+	}
+
+	specs, err := cfg.SetSpecs()
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		if spec.Verdict == VerdictReject {
+			return fmt.Errorf("set %s: %w", spec.Name, ErrRejectIngress)
+		}
+	}
+
+	return nil
+}
+
+// NFTOnlyOptions возвращает заданные параметры, которые используются только nftables.
+func (cfg Config) NFTOnlyOptions() []string {
+	var rv []string
+
+	if cfg.ChainHook != "" && cfg.ChainHook != HookInput {
+		rv = append(rv, "--hook")
+	}
+
+	if cfg.ChainDevice != "" {
+		rv = append(rv, "--device")
+	}
+
+	if cfg.ChainPriority != 0 {
+		rv = append(rv, "--priority")
+	}
+
+	if cfg.ChainPolicy != "" && cfg.ChainPolicy != VerdictAccept {
+		rv = append(rv, "--policy")
+	}
+
+	if cfg.Attach {
+		rv = append(rv, "--attach")
+	}
+
+	return rv
+}
+
+// PositionHandle возвращает номер правила, перед которым добавляются правила fwset.
+// Для last и first возвращается 0.
+func (cfg Config) PositionHandle() (uint64, error) {
+	switch cfg.Position {
+	case "", PositionLast, PositionFirst:
+		return 0, nil
+	}
+
+	handle, err := strconv.ParseUint(cfg.Position, 10, 64)
+	if err != nil || handle == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidPosition, cfg.Position)
+	}
+
+	return handle, nil
+}
+
+// SetName возвращает имя сета по умолчанию для accept или drop.
+func (cfg Config) SetName(accept bool) string {
+	if accept {
+		return cfg.SetNameAccept
+	}
+
+	return cfg.SetNameDrop
+}
+
+// ChangeSet возвращает имя сета изменения, если оно не задано - сет по умолчанию.
+func (cfg Config) ChangeSet(change Change) string {
+	if change.Set != "" {
+		return change.Set
+	}
+
+	return cfg.SetName(change.Accept)
+}
+
+// SetSpecs возвращает сеты по умолчанию (accept, drop) и дополнительные сеты в порядке приоритета.
+func (cfg Config) SetSpecs() ([]SetSpec, error) {
+	accept := SetSpec{Name: cfg.SetNameAccept, Rule: cfg.RuleAccept}
+	if accept.Verdict == "" {
+		accept.Verdict = VerdictAccept
+	}
+
+	specs := make([]SetSpec, 0, 2+len(cfg.Sets))
+	specs = append(specs, accept, SetSpec{Name: cfg.SetNameDrop, Rule: cfg.RuleDrop})
+	specs = append(specs, cfg.Sets...)
+
+	seen := make(map[string]bool, len(specs))
+
+	for i := range specs {
+		spec := &specs[i]
+		if spec.Name == "" {
+			return nil, ErrNoSetName
+		}
+
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateSet, spec.Name)
+		}
+
+		seen[spec.Name] = true
+
+		if spec.Verdict == "" {
+			spec.Verdict = VerdictDrop
+		}
+
+		if err := spec.Validate(); err != nil {
+			return nil, fmt.Errorf("set %s: %w", spec.Name, err)
+		}
+	}
+
+	slices.SortStableFunc(specs, func(a, b SetSpec) int { return cmp.Compare(a.Priority, b.Priority) })
+
+	return specs, nil
+}
+
+// SetSpec возвращает описание сета по имени.
+func (cfg Config) SetSpec(name string) (SetSpec, error) {
+	specs, err := cfg.SetSpecs()
+	if err != nil {
+		return SetSpec{}, err
+	}
+
+	for _, spec := range specs {
+		if spec.Name == name {
+			return spec, nil
+		}
+	}
+
+	return SetSpec{}, fmt.Errorf("%w: %s", ErrUnknownSet, name)
+}
+
+// Validate проверяет описание сета и задает значения по умолчанию.
+func (spec *SetSpec) Validate() error {
+	if spec.Name == "" {
+		return ErrNoSetName
+	}
+
+	switch spec.Match {
+	case "":
+		spec.Match = MatchSrc
+	case MatchSrc, MatchDst:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMatch, spec.Match)
+	}
+
+	switch spec.Protocol {
+	case "", ProtocolICMP:
+		if len(spec.Ports) > 0 {
+			return ErrPortsNoProtocol
+		}
+	case ProtocolTCP, ProtocolUDP:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownProtocol, spec.Protocol)
+	}
+
+	switch spec.Verdict {
+	case VerdictAccept, VerdictDrop:
+	case VerdictReject:
+		if spec.RejectWith == "" {
+			spec.RejectWith = RejectPortUnreachable
+		}
+
+		if spec.RejectWith == RejectTCPReset && spec.Protocol != "" && spec.Protocol != ProtocolTCP {
+			return ErrResetNotTCP
+		}
+	case VerdictJump:
+		if spec.Jump == "" {
+			return ErrNoJumpChain
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownVerdict, spec.Verdict)
+	}
+
+	if spec.LogRate != "" {
+		if _, _, err := spec.Rate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Level возвращает номер уровня логирования, по умолчанию - warn.
+func (r Rule) Level() int {
+	if i := slices.Index(LogLevels, r.LogLevel); i >= 0 {
+		return i
+	}
+
+	return slices.Index(LogLevels, "warn")
+}
+
+// Rate возвращает ограничение частоты логирования вида 10/minute как число пакетов и единицу.
+func (r Rule) Rate() (uint64, string, error) {
+	count, unit, ok := strings.Cut(r.LogRate, "/")
+	if !ok {
+		return 0, "", fmt.Errorf("%w: %s", ErrInvalidRate, r.LogRate)
+	}
+
+	n, err := strconv.ParseUint(count, 10, 64)
+	if _, known := RateUnits[unit]; err != nil || !known || n == 0 {
+		return 0, "", fmt.Errorf("%w: %s", ErrInvalidRate, r.LogRate)
+	}
+
+	return n, unit, nil
+}
+
+// Change описывает изменение содержимого сета.
+type Change struct {
+	Set      string // имя сета, если не задано - сет accept или drop
+	Accept   bool
+	Add      bool
+	Networks []string
+	Timeout  time.Duration // время жизни добавляемых элементов, 0 - без ограничения
+	Comment  string        // комментарий добавляемых элементов
+}
+
+// Element описывает элемент сета.
+type Element struct {
+	Network string
+	Expires time.Duration // оставшееся время жизни, 0 - без ограничения
+	Comment string
 }
@@ -0,0 +1,164 @@
+package fwset
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/LeKovr/fwset/utils"
+)
+
+const (
+	VerdictAccept = "accept"
+	VerdictDrop   = "drop"
+
+	ElementHost  = "host"
+	ElementCIDR  = "cidr"
+	ElementRange = "range"
+
+	OutputPlain = "plain"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+	OutputCSV   = "csv"
+)
+
+// Set описывает содержимое сета для вывода и экспорта.
+type Set struct {
+	Name     string    `json:"name"            yaml:"name"`
+	Verdict  string    `json:"verdict"         yaml:"verdict"`
+	Backend  string    `json:"backend"         yaml:"backend"`
+	Table    string    `json:"table,omitempty" yaml:"table,omitempty"`
+	Elements []Element `json:"elements"        yaml:"elements"`
+}
+
+// Element описывает элемент сета.
+type Element struct {
+	Network string `json:"network"           yaml:"network"`
+	Type    string `json:"type"              yaml:"type"`
+	Expires string `json:"expires,omitempty" yaml:"expires,omitempty"` // оставшееся время жизни
+	Comment string `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
+
+// Sets возвращает содержимое всех сетов в порядке приоритета.
+func (fw *Firewall) Sets() ([]Set, error) {
+	table := ""
+	if fw.config.FW == FWNameNFTables {
+		table = fw.config.TableName
+	}
+
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]Set, 0, len(specs))
+
+	for _, spec := range specs {
+		elements, err := fw.ListElements(spec.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		set := Set{
+			Name:     spec.Name,
+			Verdict:  spec.Verdict,
+			Backend:  fw.config.FW,
+			Table:    table,
+			Elements: make([]Element, len(elements)),
+		}
+
+		for i, elem := range elements {
+			set.Elements[i] = Element{Network: elem.Network, Type: ElementType(elem.Network), Comment: elem.Comment}
+			if elem.Expires > 0 {
+				set.Elements[i].Expires = elem.Expires.Round(time.Second).String()
+			}
+		}
+
+		sets = append(sets, set)
+	}
+
+	return sets, nil
+}
+
+// ElementType возвращает тип элемента: адрес хоста, сеть или диапазон.
+func ElementType(network string) string {
+	r, err := utils.ParseRange(network)
+
+	switch {
+	case err == nil && r.Start == r.End:
+		return ElementHost
+	case strings.Contains(network, "-"):
+		return ElementRange
+	default:
+		return ElementCIDR
+	}
+}
+
+// ExportSets выводит сеты в формате, который читает ReadState: yaml (по умолчанию) или json.
+func ExportSets(w io.Writer, format string, sets []Set) error {
+	switch format {
+	case "":
+		format = OutputYAML
+	case OutputJSON, OutputYAML:
+	default:
+		return fmt.Errorf("%w: %s", ErrNoStateOutput, format)
+	}
+
+	return WriteSets(w, format, sets)
+}
+
+// WriteSets выводит сеты в заданном формате.
+// Форматы json и yaml можно передать команде apply, plain выводит сети под заголовком `<set> (<verdict>):`.
+func WriteSets(w io.Writer, format string, sets []Set) error {
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(State{Sets: sets})
+	case OutputYAML:
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+
+		return enc.Encode(State{Sets: sets})
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"set", "verdict", "backend", "table", "type", "network", "expires", "comment"})
+
+		for _, set := range sets {
+			for _, elem := range set.Elements {
+				_ = cw.Write([]string{set.Name, set.Verdict, set.Backend, set.Table, elem.Type, elem.Network, elem.Expires, elem.Comment})
+			}
+		}
+
+		cw.Flush()
+
+		return cw.Error()
+	case OutputPlain:
+		for _, set := range sets {
+			fmt.Fprintf(w, "%s (%s):\n", set.Name, set.Verdict)
+
+			for _, elem := range set.Elements {
+				line := elem.Network
+				if elem.Expires != "" {
+					line += " (expires in " + elem.Expires + ")"
+				}
+
+				if elem.Comment != "" {
+					line += " # " + elem.Comment // строку можно передать в add --from_file
+				}
+
+				fmt.Fprintln(w, line)
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownOutput, format)
+	}
+}
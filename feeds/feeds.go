@@ -0,0 +1,120 @@
+// Package feeds holds parsers of public blocklist formats.
+package feeds
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/LeKovr/fwset/utils"
+)
+
+const (
+	FormatNetset   = "netset"
+	FormatSpamhaus = "spamhaus"
+	FormatP2P      = "p2p"
+)
+
+// Parser возвращает сеть из строки фида или пустую строку, если строку надо пропустить.
+type Parser func(line string) (string, error)
+
+// Parsers содержит парсеры строк для поддерживаемых форматов.
+var Parsers = map[string]Parser{
+	FormatNetset:   ParseNetset,
+	FormatSpamhaus: ParseSpamhaus,
+	FormatP2P:      ParseP2P,
+}
+
+// ErrUnknownFormat возвращается для неподдерживаемого формата фида.
+var ErrUnknownFormat = errors.New("unknown feed format")
+
+// Read читает фид в заданном формате и возвращает сети в виде, принимаемом Firewall.Add.
+func Read(format string, r io.Reader) ([]string, error) {
+	parser, ok := Parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+
+	var networks []string
+
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		network, err := parser(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i, err)
+		}
+
+		if network == "" {
+			continue
+		}
+
+		if _, err := utils.ParseRange(network); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, scanner.Err()
+}
+
+// ParseNetset разбирает строку FireHOL .netset: адрес или сеть, комментарии начинаются с #.
+func ParseNetset(line string) (string, error) {
+	line, _, _ = strings.Cut(line, "#")
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	return fields[0], nil
+}
+
+// ParseSpamhaus разбирает строку Spamhaus DROP/EDROP вида "1.10.16.0/20 ; SBL256894".
+func ParseSpamhaus(line string) (string, error) {
+	network, _, _ := strings.Cut(line, ";")
+
+	return strings.TrimSpace(network), nil
+}
+
+// ParseP2P разбирает строку PeerGuardian вида "name:1.2.3.4-1.2.3.9".
+func ParseP2P(line string) (string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	// имя может содержать ":", диапазон идет после последнего
+	i := strings.LastIndex(line, ":")
+	if i < 0 {
+		return "", fmt.Errorf("no range in %q", line)
+	}
+
+	first, last, ok := strings.Cut(line[i+1:], "-")
+	if !ok {
+		return "", fmt.Errorf("no range in %q", line)
+	}
+
+	first, last = trimZeros(first), trimZeros(last)
+	if first == last {
+		return first, nil
+	}
+
+	return first + "-" + last, nil
+}
+
+// trimZeros убирает ведущие нули в октетах (001.002.003.004), которые не принимает net.ParseIP.
+func trimZeros(ip string) string {
+	octets := strings.Split(strings.TrimSpace(ip), ".")
+	for i, o := range octets {
+		if t := strings.TrimLeft(o, "0"); t != "" {
+			octets[i] = t
+		} else if o != "" {
+			octets[i] = "0"
+		}
+	}
+
+	return strings.Join(octets, ".")
+}
@@ -0,0 +1,44 @@
+package feeds
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		format string
+		file   string
+		want   []string
+	}{
+		{FormatNetset, "testdata/firehol_level1.netset", []string{"0.0.0.0/8", "1.10.16.0/20", "1.19.0.0/16", "2.56.192.0/22", "5.188.10.179"}},
+		{FormatSpamhaus, "testdata/drop.txt", []string{"1.10.16.0/20", "1.19.0.0/16", "2.56.192.0/22"}},
+		{FormatP2P, "testdata/level1.p2p", []string{"1.2.4.0-1.2.4.255", "1.10.16.0-1.10.31.255", "2.56.192.10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			f, err := os.Open(tt.file)
+			assert.NoError(t, err)
+			defer f.Close()
+
+			got, err := Read(tt.format, f)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	_, err := Read("unknown", strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	_, err = Read(FormatP2P, strings.NewReader("name without range\n"))
+	assert.ErrorContains(t, err, "line 1")
+
+	_, err = Read(FormatSpamhaus, strings.NewReader("; header\n1.2.3.0/33 ; SBL1\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
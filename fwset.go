@@ -2,7 +2,10 @@ package fwset
 
 import (
 	"errors"
+	"log/slog"
+	"time"
 
+	"github.com/LeKovr/fwset/audit"
 	"github.com/LeKovr/fwset/config"
 	"github.com/LeKovr/fwset/ipset"
 	"github.com/LeKovr/fwset/nftables"
@@ -10,17 +13,22 @@ import (
 
 // Config содержит тип и стандартные настройки фаервола.
 type Config struct {
-	FW string `choice:"nft" choice:"ipset" default:"nft" description:"Firewall type" env:"FW" long:"fw"` //nolint:staticcheck
+	FW       string `choice:"nft"                                                     choice:"ipset"  default:"nft"    description:"Firewall type" env:"FW" long:"fw"` //nolint:staticcheck
+	SetsFile string `description:"YAML file with additional named sets"               env:"SETS_FILE" long:"sets_file"`
+	Strict   bool   `description:"Refuse adds overlapping a set with another verdict" env:"STRICT"    long:"strict"`
 	config.Config
 }
 
 // FWTables описывает общий для фаерволов интерфейс.
 type FWTables interface {
-	Create(accept bool) error
-	Modify(accept, add bool, networks []string) error
-	Add(accept bool, networks []string) error
-	Remove(accept bool, networks []string) error
-	List(accept bool) ([]string, error)
+	Create(spec config.SetSpec) error
+	Modify(set string, add bool, networks []string) error
+	Add(set string, networks []string) error
+	Remove(set string, networks []string) error
+	List(set string) ([]string, error)
+	ListElements(set string) ([]config.Element, error)
+	Apply(changes []config.Change) error
+	Replace(change config.Change) error
 	Destroy() error
 }
 
@@ -28,6 +36,8 @@ type FWTables interface {
 type Firewall struct {
 	config  Config
 	handler FWTables
+	audit   audit.Sink
+	origin  audit.Origin
 }
 
 const (
@@ -35,8 +45,16 @@ const (
 	FWNameIPSet    = "ipset"
 )
 
-// ErrNotImplemented возвращается при попытке инициализировать нереализованный фаервол.
-var ErrNotImplemented = errors.New("not implemented")
+var (
+	// ErrNotImplemented возвращается при попытке инициализировать нереализованный фаервол.
+	ErrNotImplemented = errors.New("not implemented")
+
+	// ErrUnknownOutput возвращается для неподдерживаемого формата вывода.
+	ErrUnknownOutput = errors.New("unknown output format")
+
+	// ErrNoStateOutput возвращается при экспорте в формате, который не читает команда apply.
+	ErrNoStateOutput = errors.New("output format can not be applied")
+)
 
 // New возвращает экземпляр фаервола.
 func New(cfg Config) (*Firewall, error) {
@@ -45,6 +63,16 @@ func New(cfg Config) (*Firewall, error) {
 		err     error
 	)
 
+	if cfg.SetsFile != "" {
+		if cfg.Sets, err = LoadSets(cfg.SetsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = cfg.SetSpecs(); err != nil {
+		return nil, err
+	}
+
 	switch cfg.FW {
 	case FWNameNFTables:
 		handler, err = nftables.New(cfg.Config)
@@ -58,36 +86,172 @@ func New(cfg Config) (*Firewall, error) {
 		return nil, err
 	}
 
+	return NewFirewall(cfg, handler), nil
+}
+
+// NewFirewall возвращает фаервол с заданным обработчиком, например, для тестов.
+func NewFirewall(cfg Config, handler FWTables) *Firewall {
 	return &Firewall{
 		config:  cfg,
 		handler: handler,
-	}, nil
+	}
+}
+
+// SetAudit включает запись изменений в журнал от имени origin.
+func (fw *Firewall) SetAudit(sink audit.Sink, origin audit.Origin) {
+	fw.audit = sink
+	fw.origin = origin
+}
+
+// Origin возвращает сведения о том, от чьего имени пишется журнал.
+func (fw *Firewall) Origin() audit.Origin {
+	return fw.origin
+}
+
+// SetOrigin задает, от чьего имени пишутся следующие записи журнала.
+func (fw *Firewall) SetOrigin(origin audit.Origin) {
+	fw.origin = origin
 }
 
+// Create создает все сеты в порядке приоритета.
 func (fw *Firewall) Create() error {
-	if err := fw.handler.Create(true); err != nil {
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
 		return err
 	}
 
-	return fw.handler.Create(false)
+	for _, spec := range specs {
+		if err = fw.CreateSet(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateSet создает сет и правило для него.
+func (fw *Firewall) CreateSet(spec config.SetSpec) error {
+	err := fw.handler.Create(spec)
+	fw.record(audit.Record{Action: audit.ActionCreate, Set: spec.Name}, err)
+
+	return err
+}
+
+// ListSets возвращает описания сетов в порядке приоритета.
+func (fw *Firewall) ListSets() ([]config.SetSpec, error) {
+	return fw.config.SetSpecs()
 }
 
-func (fw *Firewall) Modify(accept, add bool, networks []string) error {
-	return fw.handler.Modify(accept, add, networks)
+// SetSpec возвращает описание сета по имени, для неизвестного сета - ErrUnknownSet.
+func (fw *Firewall) SetSpec(name string) (config.SetSpec, error) {
+	return fw.config.SetSpec(name)
 }
 
-func (fw *Firewall) Add(accept bool, networks []string) error {
-	return fw.handler.Add(accept, networks)
+// SetName возвращает имя сета accept или drop.
+func (fw *Firewall) SetName(accept bool) string {
+	return fw.config.SetName(accept)
 }
 
-func (fw *Firewall) Remove(accept bool, networks []string) error {
-	return fw.handler.Remove(accept, networks)
+// Modify добавляет (см. AddChange) или удаляет (см. Remove) сети с учетом содержимого сета.
+func (fw *Firewall) Modify(set string, add bool, networks []string) error {
+	if add {
+		return fw.Add(set, networks)
+	}
+
+	return fw.Remove(set, networks)
+}
+
+// Add добавляет сети в сет с учетом его содержимого, см. AddChange.
+func (fw *Firewall) Add(set string, networks []string) error {
+	return fw.AddChange(config.Change{Set: set, Add: true, Networks: networks})
 }
 
-func (fw *Firewall) List(accept bool) ([]string, error) {
-	return fw.handler.List(accept)
+// Remove удаляет сети из сета. Элементы, которые покрывают сети частично, например
+// объединенные при добавлении, заменяются оставшимися частями в той же операции Apply.
+func (fw *Firewall) Remove(set string, networks []string) error {
+	return fw.RemoveChange(config.Change{Set: set, Networks: networks})
+}
+
+// RemoveChange удаляет сети изменения из сета изменения, как Remove.
+func (fw *Firewall) RemoveChange(change config.Change) error {
+	change.Add = false
+
+	changes, err := fw.reconcileRemove(change)
+	if err != nil {
+		fw.recordChange(change, err)
+
+		return err
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return fw.Apply(changes)
+}
+
+func (fw *Firewall) List(set string) ([]string, error) {
+	return fw.handler.List(set)
+}
+
+func (fw *Firewall) ListElements(set string) ([]config.Element, error) {
+	return fw.handler.ListElements(set)
 }
 
 func (fw *Firewall) Destroy() error {
-	return fw.handler.Destroy()
+	err := fw.handler.Destroy()
+	fw.record(audit.Record{Action: audit.ActionDestroy}, err)
+
+	return err
+}
+
+// recordChange пишет в журнал изменение содержимого сета.
+func (fw *Firewall) recordChange(change config.Change, err error) {
+	fw.recordSetChange(change, false, err)
+}
+
+// recordSetChange пишет в журнал изменение или замену (replace) содержимого сета.
+func (fw *Firewall) recordSetChange(change config.Change, replace bool, err error) {
+	rec := audit.Record{
+		Action:   audit.ActionRemove,
+		Set:      fw.config.ChangeSet(change),
+		Networks: change.Networks,
+	}
+	if change.Add {
+		rec.Action = audit.ActionAdd
+	}
+
+	if replace {
+		rec.Action = audit.ActionReplace
+	}
+
+	if change.Timeout > 0 {
+		rec.Timeout = change.Timeout.String()
+	}
+
+	rec.Comment = change.Comment
+
+	fw.record(rec, err)
+}
+
+// record дополняет запись и пишет ее в журнал, если он включен.
+// Ошибка записи не отменяет выполненное изменение и только логируется.
+func (fw *Firewall) record(rec audit.Record, err error) {
+	if fw.audit == nil {
+		return
+	}
+
+	rec.Time = time.Now()
+	rec.Origin = fw.origin
+	rec.Backend = fw.config.FW
+	rec.Result = audit.ResultOK
+
+	if err != nil {
+		rec.Result = audit.ResultError
+		rec.Error = err.Error()
+	}
+
+	if err := fw.audit.Write(rec); err != nil {
+		slog.Error("Audit", "err", err)
+	}
 }
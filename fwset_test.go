@@ -1,8 +1,12 @@
 package fwset
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/LeKovr/fwset/audit"
 	"github.com/LeKovr/fwset/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -10,9 +14,10 @@ import (
 
 var cfg = Config{
 	Config: config.Config{
-		TableName:   "test_table",
-		ChainName:   "input",
-		SetNameDrop: "test_set",
+		TableName:     "test_table",
+		ChainName:     "input",
+		SetNameDrop:   "test_set",
+		SetNameAccept: "test_accept",
 	},
 }
 
@@ -20,27 +25,40 @@ type MockNFT struct {
 	mock.Mock
 }
 
-func (m *MockNFT) Create(accept bool) error {
-	return m.Called(accept).Error(0)
+func (m *MockNFT) Create(spec config.SetSpec) error {
+	return m.Called(spec).Error(0)
 }
 
-func (m *MockNFT) Modify(accept, add bool, networks []string) error {
-	return m.Called(accept, add, networks).Error(0)
+func (m *MockNFT) Modify(set string, add bool, networks []string) error {
+	return m.Called(set, add, networks).Error(0)
 }
 
-func (m *MockNFT) Add(accept bool, networks []string) error {
-	return m.Called(accept, networks).Error(0)
+func (m *MockNFT) Add(set string, networks []string) error {
+	return m.Called(set, networks).Error(0)
 }
 
-func (m *MockNFT) Remove(accept bool, networks []string) error {
-	return m.Called(accept, networks).Error(0)
+func (m *MockNFT) Remove(set string, networks []string) error {
+	return m.Called(set, networks).Error(0)
 }
 
-func (m *MockNFT) List(accept bool) ([]string, error) {
-	args := m.Called(accept)
+func (m *MockNFT) List(set string) ([]string, error) {
+	args := m.Called(set)
 	return args.Get(0).([]string), args.Error(1)
 }
 
+func (m *MockNFT) ListElements(set string) ([]config.Element, error) {
+	args := m.Called(set)
+	return args.Get(0).([]config.Element), args.Error(1)
+}
+
+func (m *MockNFT) Apply(changes []config.Change) error {
+	return m.Called(changes).Error(0)
+}
+
+func (m *MockNFT) Replace(change config.Change) error {
+	return m.Called(change).Error(0)
+}
+
 func (m *MockNFT) Destroy() error {
 	return m.Called().Error(0)
 }
@@ -54,7 +72,7 @@ func TestAdd(t *testing.T) {
 	}{
 		{"Valid IP", "192.168.1.1", false, true},
 		{"Valid CIDR", "10.0.0.0/24", false, true},
-		//	{"Invalid", "invalid", true, true},
+		{"Invalid", "invalid", true, false},
 	}
 
 	for _, tt := range tests {
@@ -63,10 +81,12 @@ func TestAdd(t *testing.T) {
 			fw := &Firewall{config: cfg, handler: mockNFT}
 
 			if tt.mockCall {
-				mockNFT.On("Add", false, []string{tt.input}).Return(nil)
+				mockNFT.On("ListElements", "test_accept").Return([]config.Element{}, nil)
+				mockNFT.On("ListElements", "test_set").Return([]config.Element{}, nil)
+				mockNFT.On("Apply", []config.Change{{Set: "test_set", Add: true, Networks: []string{tt.input}}}).Return(nil)
 			}
 
-			err := fw.Add(false, []string{tt.input})
+			err := fw.Add("test_set", []string{tt.input})
 			if tt.wantErr {
 				assert.Error(t, err)
 			} else {
@@ -81,26 +101,35 @@ func TestRemove(t *testing.T) {
 	tests := []struct {
 		name     string
 		input    string
-		wantErr  bool
-		mockCall bool
+		elements []config.Element
+		want     []config.Change
+		wantErr  error
 	}{
-		{"Valid IP", "192.168.1.1", false, true},
-		{"Valid CIDR", "10.0.0.0/24", false, true},
-		// {"Invalid", "invalid", true, false},
+		{"Valid IP", "192.168.1.1", []config.Element{{Network: "192.168.1.1"}},
+			[]config.Change{{Set: "test_set", Networks: []string{"192.168.1.1"}}}, nil},
+		{"Valid CIDR", "10.0.0.0/24", []config.Element{{Network: "10.0.0.0/24"}, {Network: "10.0.1.1"}},
+			[]config.Change{{Set: "test_set", Networks: []string{"10.0.0.0/24"}}}, nil},
+		{"Split", "10.0.0.1", []config.Element{{Network: "10.0.0.0/30", Expires: time.Minute, Comment: "scan"}},
+			[]config.Change{
+				{Set: "test_set", Networks: []string{"10.0.0.0/30"}},
+				{Set: "test_set", Add: true, Networks: []string{"10.0.0.0", "10.0.0.2/31"}, Timeout: time.Minute, Comment: "scan"},
+			}, nil},
+		{"Missing", "10.0.1.1", []config.Element{{Network: "10.0.0.0/24"}}, nil, ErrNotInSet},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockNFT := new(MockNFT)
 			fw := &Firewall{config: cfg, handler: mockNFT}
+			mockNFT.On("ListElements", "test_set").Return(tt.elements, nil)
 
-			if tt.mockCall {
-				mockNFT.On("Remove", false, []string{tt.input}).Return(nil)
+			if tt.want != nil {
+				mockNFT.On("Apply", tt.want).Return(nil)
 			}
 
-			err := fw.Remove(false, []string{tt.input})
-			if tt.wantErr {
-				assert.Error(t, err)
+			err := fw.Remove("test_set", []string{tt.input})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
 			} else {
 				assert.NoError(t, err)
 			}
@@ -109,14 +138,576 @@ func TestRemove(t *testing.T) {
 	}
 }
 
+func TestAddAddRemove(t *testing.T) {
+	mockNFT := new(MockNFT)
+	fw := &Firewall{config: cfg, handler: mockNFT}
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{}, nil)
+
+	// содержимое сета читается для объединения и для проверки пересечений
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{}, nil).Twice()
+	mockNFT.On("Apply", []config.Change{{Set: "test_set", Add: true, Networks: []string{"10.0.0.0/24"}}}).Return(nil).Once()
+	assert.NoError(t, fw.Add("test_set", []string{"10.0.0.0/24"}))
+
+	// смежная сеть объединяется с элементом
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{{Network: "10.0.0.0/24"}}, nil).Twice()
+	mockNFT.On("Apply", []config.Change{
+		{Set: "test_set", Networks: []string{"10.0.0.0/24"}},
+		{Set: "test_set", Add: true, Networks: []string{"10.0.0.0/23"}},
+	}).Return(nil).Once()
+	assert.NoError(t, fw.Add("test_set", []string{"10.0.1.0/24"}))
+
+	// первую сеть можно удалить, элемент заменяется оставшейся частью
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{{Network: "10.0.0.0/23"}}, nil).Once()
+	mockNFT.On("Apply", []config.Change{
+		{Set: "test_set", Networks: []string{"10.0.0.0/23"}},
+		{Set: "test_set", Add: true, Networks: []string{"10.0.1.0/24"}},
+	}).Return(nil).Once()
+	assert.NoError(t, fw.Remove("test_set", []string{"10.0.0.0/24"}))
+	mockNFT.AssertExpectations(t)
+}
+
 func TestList(t *testing.T) {
 	mockNFT := new(MockNFT)
 	fw := &Firewall{config: cfg, handler: mockNFT}
 
 	expected := []string{"192.168.1.1/32", "10.0.0.0/24"}
-	mockNFT.On("List", false).Return(expected, nil)
+	mockNFT.On("List", "test_set").Return(expected, nil)
 
-	result, err := fw.List(false)
+	result, err := fw.List("test_set")
 	assert.NoError(t, err)
 	assert.Equal(t, expected, result)
 }
+
+func TestPlan(t *testing.T) {
+	mockNFT := new(MockNFT)
+	fw := &Firewall{config: cfg, handler: mockNFT}
+
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{{Network: "10.10.10.0/24"}}, nil)
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{{Network: "11.11.11.11"}, {Network: "11.11.12.0/24"}}, nil)
+
+	state, err := ReadState(strings.NewReader(`
+accept:
+  - 10.10.10.1/24
+drop:
+  - 11.11.12.0/24
+  - 11.11.13.2-11.11.13.16
+  - 11.11.13.2-11.11.13.16
+`))
+	assert.NoError(t, err)
+
+	changes, err := fw.Plan(*state)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Change{
+		{Set: "test_set", Add: false, Networks: []string{"11.11.11.11"}},
+		{Set: "test_set", Add: true, Networks: []string{"11.11.13.2-11.11.13.16"}},
+	}, changes)
+
+	mockNFT.On("Apply", changes).Return(nil)
+	assert.NoError(t, fw.Apply(changes))
+	mockNFT.AssertExpectations(t)
+
+	// опечатка в ключе и пустой файл не очищают сеты
+	_, err = ReadState(strings.NewReader("dorp:\n  - 11.11.12.0/24\n"))
+	assert.ErrorContains(t, err, "field dorp not found")
+
+	_, err = ReadState(strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrEmptyState)
+
+	state, err = ReadState(strings.NewReader("accept: []\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, state.Accept)
+
+	_, err = fw.Plan(State{Drop: []string{"invalid"}})
+	assert.Error(t, err)
+
+	_, err = fw.Plan(State{Sets: []Set{{Name: "unknown", Elements: []Element{{Network: "10.0.0.1"}}}}})
+	assert.ErrorIs(t, err, config.ErrUnknownSet)
+}
+
+func TestPlanOverlapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements []config.Element
+		drop     []string
+		want     []config.Change
+	}{
+		{"Overlapping", nil, []string{"10.1.2.3", "10.0.0.0/8", "10.0.0.0/24"},
+			[]config.Change{{Set: "test_set", Add: true, Networks: []string{"10.0.0.0/8"}}}},
+		{"Merged", []config.Element{{Network: "10.0.0.0/23"}}, []string{"10.0.0.0/24", "10.0.1.0/24"}, nil},
+		{"IPSetRange", []config.Element{{Network: "11.11.13.2/31"}, {Network: "11.11.13.4/30"}, {Network: "11.11.13.8/29"}, {Network: "11.11.13.16"}},
+			[]string{"11.11.13.2-11.11.13.16"}, nil},
+		{"Partial", []config.Element{{Network: "10.0.0.0/23", Comment: "office"}}, []string{"10.0.1.0/24", "10.0.2.1"},
+			[]config.Change{
+				{Set: "test_set", Networks: []string{"10.0.0.0/23"}},
+				{Set: "test_set", Add: true, Networks: []string{"10.0.1.0/24"}, Comment: "office"},
+				{Set: "test_set", Add: true, Networks: []string{"10.0.2.1"}},
+			}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockNFT := new(MockNFT)
+			fw := &Firewall{config: cfg, handler: mockNFT}
+			mockNFT.On("ListElements", "test_accept").Return([]config.Element{}, nil)
+			mockNFT.On("ListElements", "test_set").Return(tt.elements, nil)
+
+			changes, err := fw.Plan(State{Drop: tt.drop})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, changes)
+		})
+	}
+}
+
+func TestPlanStrict(t *testing.T) {
+	mockNFT := new(MockNFT)
+	scfg := cfg
+	scfg.Strict = true
+	fw := &Firewall{config: scfg, handler: mockNFT}
+
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{{Network: "10.0.0.5"}}, nil)
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{}, nil)
+
+	// адрес переносится из accept в drop: пересечения после изменений нет
+	changes, err := fw.Plan(State{Drop: []string{"10.0.0.0/24"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Change{
+		{Set: "test_accept", Networks: []string{"10.0.0.5"}},
+		{Set: "test_set", Add: true, Networks: []string{"10.0.0.0/24"}},
+	}, changes)
+
+	_, err = fw.Plan(State{Accept: []string{"10.0.0.5"}, Drop: []string{"10.0.0.0/24"}})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	// существующее пересечение без добавлений не мешает
+	mockNFT.On("ListElements", "test_set").Unset()
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{{Network: "10.0.0.0/24"}}, nil)
+
+	changes, err = fw.Plan(State{Accept: []string{"10.0.0.5"}, Drop: []string{"10.0.0.0/24"}})
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestNamedSets(t *testing.T) {
+	mockNFT := new(MockNFT)
+	ncfg := cfg
+	ncfg.Sets = []config.SetSpec{
+		{Name: "scanners", Rule: config.Rule{Verdict: config.VerdictReject}, Priority: -1},
+		{Name: "tarpit", Rule: config.Rule{Verdict: config.VerdictJump, Jump: "tarpit"}},
+	}
+	fw := &Firewall{config: ncfg, handler: mockNFT}
+
+	specs, err := fw.ListSets()
+	assert.NoError(t, err)
+
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.Name
+	}
+
+	assert.Equal(t, []string{"scanners", "test_accept", "test_set", "tarpit"}, names)
+	assert.Equal(t, config.RejectPortUnreachable, specs[0].RejectWith)
+	assert.Equal(t, config.VerdictDrop, specs[2].Verdict)
+
+	spec, err := fw.SetSpec("tarpit")
+	assert.NoError(t, err)
+	assert.Equal(t, "tarpit", spec.Jump)
+
+	_, err = fw.SetSpec("tarpt")
+	assert.ErrorIs(t, err, config.ErrUnknownSet)
+
+	for _, spec := range specs {
+		mockNFT.On("Create", spec).Return(nil).Once()
+	}
+
+	assert.NoError(t, fw.Create())
+
+	mockNFT.On("ListElements", "scanners").Return([]config.Element{}, nil)
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{}, nil)
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{}, nil)
+	mockNFT.On("ListElements", "tarpit").Return([]config.Element{{Network: "10.0.0.1"}}, nil)
+
+	changes, err := fw.Plan(State{Sets: []Set{{Name: "scanners", Elements: []Element{{Network: "192.0.2.1"}}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Change{
+		{Set: "tarpit", Networks: []string{"10.0.0.1"}},
+		{Set: "scanners", Add: true, Networks: []string{"192.0.2.1"}},
+	}, changes)
+	mockNFT.AssertExpectations(t)
+
+	dup := ncfg
+	dup.Sets = append(dup.Sets, config.SetSpec{Name: "test_set"})
+	_, err = dup.SetSpecs()
+	assert.ErrorIs(t, err, config.ErrDuplicateSet)
+
+	dup.Sets = []config.SetSpec{{Name: "x", Rule: config.Rule{Verdict: config.VerdictJump}}}
+	_, err = dup.SetSpecs()
+	assert.ErrorIs(t, err, config.ErrNoJumpChain)
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	mockNFT := new(MockNFT)
+	ecfg := cfg
+	ecfg.FW = FWNameNFTables
+	fw := &Firewall{config: ecfg, handler: mockNFT}
+
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{{Network: "10.10.10.0/24"}}, nil)
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{
+		{Network: "11.11.11.11", Expires: 14*time.Minute + 31500*time.Millisecond},
+		{Network: "11.11.13.2-11.11.13.16", Comment: "abuse ticket 123"},
+	}, nil)
+
+	sets, err := fw.Sets()
+	assert.NoError(t, err)
+
+	if assert.Len(t, sets, 2) {
+		assert.Equal(t, Set{
+			Name:    "test_set",
+			Verdict: VerdictDrop,
+			Backend: FWNameNFTables,
+			Table:   "test_table",
+			Elements: []Element{
+				{Network: "11.11.11.11", Type: ElementHost, Expires: "14m32s"},
+				{Network: "11.11.13.2-11.11.13.16", Type: ElementRange, Comment: "abuse ticket 123"},
+			},
+		}, sets[1])
+	}
+
+	// apply на пустые сеты восстанавливает элементы с оставшимся временем жизни и комментарием
+	empty := new(MockNFT)
+	empty.On("ListElements", mock.Anything).Return([]config.Element{}, nil)
+	restore := &Firewall{config: ecfg, handler: empty}
+
+	for _, format := range []string{OutputJSON, OutputYAML} {
+		var buf strings.Builder
+		assert.NoError(t, WriteSets(&buf, format, sets))
+
+		state, err := ReadState(strings.NewReader(buf.String()))
+		assert.NoError(t, err)
+
+		changes, err := restore.Plan(*state)
+		assert.NoError(t, err)
+		assert.Equal(t, []config.Change{
+			{Set: "test_accept", Add: true, Networks: []string{"10.10.10.0/24"}},
+			{Set: "test_set", Add: true, Networks: []string{"11.11.11.11"}, Timeout: 14*time.Minute + 32*time.Second},
+			{Set: "test_set", Add: true, Networks: []string{"11.11.13.2-11.11.13.16"}, Comment: "abuse ticket 123"},
+		}, changes, format)
+	}
+
+	_, err = restore.Plan(State{Sets: []Set{{Name: "test_set", Elements: []Element{{Network: "10.0.0.1", Expires: "soon"}}}}})
+	assert.Error(t, err)
+
+	// export по умолчанию пишет yaml, который читает apply
+	var buf strings.Builder
+	assert.NoError(t, ExportSets(&buf, "", sets))
+
+	state, err := ReadState(strings.NewReader(buf.String()))
+	assert.NoError(t, err)
+	assert.Len(t, state.Sets, 2)
+	assert.ErrorIs(t, ExportSets(&buf, OutputPlain, sets), ErrNoStateOutput)
+	assert.ErrorIs(t, ExportSets(&buf, OutputCSV, sets), ErrNoStateOutput)
+
+	buf.Reset()
+	assert.NoError(t, WriteSets(&buf, OutputCSV, sets))
+	assert.Equal(t, `set,verdict,backend,table,type,network,expires,comment
+test_accept,accept,nft,test_table,cidr,10.10.10.0/24,,
+test_set,drop,nft,test_table,host,11.11.11.11,14m32s,
+test_set,drop,nft,test_table,range,11.11.13.2-11.11.13.16,,abuse ticket 123
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, WriteSets(&buf, OutputPlain, sets))
+	assert.Equal(t, `test_accept (accept):
+10.10.10.0/24
+test_set (drop):
+11.11.11.11 (expires in 14m32s)
+11.11.13.2-11.11.13.16 # abuse ticket 123
+`, buf.String())
+
+	assert.ErrorIs(t, WriteSets(&buf, "xml", sets), ErrUnknownOutput)
+}
+
+type RecordingSink struct {
+	Records []audit.Record
+}
+
+func (s *RecordingSink) Write(rec audit.Record) error {
+	s.Records = append(s.Records, rec)
+	return nil
+}
+
+func (s *RecordingSink) Close() error { return nil }
+
+func TestAudit(t *testing.T) {
+	mockNFT := new(MockNFT)
+	fw := &Firewall{config: Config{FW: FWNameNFTables, Config: cfg.Config}, handler: mockNFT}
+	sink := &RecordingSink{}
+	fw.SetAudit(sink, audit.Origin{User: "root", Command: "add", Reason: "scan", Ticket: "SEC-1"})
+
+	changes := []config.Change{{Add: true, Networks: []string{"10.0.0.1"}, Timeout: time.Hour}}
+	mockNFT.On("Apply", changes).Return(nil)
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{}, errors.New("no such set"))
+	mockNFT.On("Destroy").Return(nil)
+
+	assert.NoError(t, fw.Apply(changes))
+	assert.Error(t, fw.Remove("test_accept", []string{"10.0.0.2"}))
+	assert.NoError(t, fw.Destroy())
+
+	if assert.Len(t, sink.Records, 3) {
+		rec := sink.Records[0]
+		assert.False(t, rec.Time.IsZero())
+		assert.Equal(t, "scan", rec.Reason)
+		assert.Equal(t, "SEC-1", rec.Ticket)
+		assert.Equal(t, audit.ActionAdd, rec.Action)
+		assert.Equal(t, FWNameNFTables, rec.Backend)
+		assert.Equal(t, "test_set", rec.Set)
+		assert.Equal(t, "1h0m0s", rec.Timeout)
+		assert.Equal(t, audit.ResultOK, rec.Result)
+
+		rec = sink.Records[1]
+		assert.Equal(t, audit.ActionRemove, rec.Action)
+		assert.Equal(t, audit.ResultError, rec.Result)
+		assert.Equal(t, "no such set", rec.Error)
+
+		assert.Equal(t, audit.ActionDestroy, sink.Records[2].Action)
+	}
+}
+
+func TestTx(t *testing.T) {
+	mockNFT := new(MockNFT)
+	fw := &Firewall{config: cfg, handler: mockNFT}
+
+	tx := fw.Begin()
+	assert.NoError(t, tx.Remove("test_set", []string{"10.0.0.1"}))
+	assert.NoError(t, tx.Add("test_accept", []string{"10.0.0.1"}))
+	assert.ErrorIs(t, tx.Add("unknown", []string{"10.0.0.1"}), config.ErrUnknownSet)
+	assert.Error(t, tx.Add("test_set", []string{"invalid"}))
+
+	changes := []config.Change{
+		{Set: "test_set", Networks: []string{"10.0.0.1"}},
+		{Set: "test_accept", Add: true, Networks: []string{"10.0.0.1"}},
+	}
+	assert.Equal(t, changes, tx.Changes())
+
+	// изменения сверяются с содержимым сетов: адрес переносится из объединенного диапазона,
+	// и со Strict перенос в сет с другим вердиктом конфликтом не считается
+	fw.config.Strict = true
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{{Network: "10.0.0.1"}}, nil).Once()
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{{Network: "10.0.0.0-10.0.0.3"}}, nil).Once()
+	mockNFT.On("Apply", []config.Change{
+		{Set: "test_set", Networks: []string{"10.0.0.0-10.0.0.3"}},
+		{Set: "test_set", Add: true, Networks: []string{"10.0.0.0", "10.0.0.2/31"}},
+	}).Return(nil).Once()
+	assert.NoError(t, tx.Commit())
+	assert.ErrorIs(t, tx.Commit(), ErrTxDone)
+	assert.ErrorIs(t, tx.Rollback(), ErrTxDone)
+
+	// со Strict пересечение с сетом с другим вердиктом отменяет транзакцию
+	tx = fw.Begin()
+	assert.NoError(t, tx.Add("test_accept", []string{"10.0.0.2"}))
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{}, nil).Once()
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{{Network: "10.0.0.0-10.0.0.3"}}, nil).Once()
+	assert.ErrorIs(t, tx.Commit(), ErrConflict)
+
+	// после Rollback изменения не применяются
+	tx = fw.Begin()
+	assert.NoError(t, tx.Add("test_set", []string{"10.0.0.2"}))
+	assert.NoError(t, tx.Rollback())
+	assert.ErrorIs(t, tx.Add("test_set", []string{"10.0.0.3"}), ErrTxDone)
+	assert.ErrorIs(t, tx.Commit(), ErrTxDone)
+	mockNFT.AssertExpectations(t)
+}
+
+func TestReplace(t *testing.T) {
+	mockNFT := new(MockNFT)
+	fw := &Firewall{config: cfg, handler: mockNFT}
+	sink := &RecordingSink{}
+	fw.SetAudit(sink, audit.Origin{Command: "replace"})
+
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{{Network: "192.0.2.1"}}, nil)
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{{Network: "10.0.0.1"}, {Network: "10.0.1.0/24"}, {Network: "10.0.2.0/24"}}, nil).Twice()
+	mockNFT.On("Replace", config.Change{Set: "test_set", Add: true, Networks: []string{"10.0.1.0/24", "10.0.3.1", "10.0.4.0/24"}}).Return(nil)
+
+	added, removed, err := fw.Replace(config.Change{Set: "test_set", Networks: []string{"10.0.1.0/24", "10.0.3.1", "10.0.4.0/24", "10.0.3.1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	assert.Equal(t, 2, removed)
+	mockNFT.AssertExpectations(t)
+
+	if assert.Len(t, sink.Records, 1) {
+		assert.Equal(t, audit.ActionReplace, sink.Records[0].Action)
+		assert.Equal(t, "test_set", sink.Records[0].Set)
+	}
+
+	// ipset хранит диапазон разбитым на CIDR: покрытие не изменилось
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{{Network: "10.0.5.0/31"}, {Network: "10.0.5.2"}}, nil)
+	mockNFT.On("Replace", config.Change{Set: "test_set", Add: true, Networks: []string{"10.0.5.0-10.0.5.2"}}).Return(nil).Once()
+
+	added, removed, err = fw.Replace(config.Change{Set: "test_set", Networks: []string{"10.0.5.0-10.0.5.2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, added)
+	assert.Equal(t, 0, removed)
+
+	// со Strict пересечение с сетом accept отменяет замену
+	fw.config.Strict = true
+	_, _, err = fw.Replace(config.Change{Set: "test_set", Networks: []string{"192.0.2.0/24"}})
+	assert.ErrorIs(t, err, ErrConflict)
+	mockNFT.AssertExpectations(t)
+
+	_, _, err = fw.Replace(config.Change{Set: "test_set", Networks: []string{"invalid"}})
+	assert.Error(t, err)
+}
+
+func TestAddReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements []config.Element
+		change   config.Change
+		want     []config.Change
+		wantErr  error
+	}{
+		{"Supernet", []config.Element{{Network: "10.1.2.3"}, {Network: "192.0.2.1"}},
+			config.Change{Networks: []string{"10.0.0.0/8"}},
+			[]config.Change{
+				{Networks: []string{"10.1.2.3"}},
+				{Add: true, Networks: []string{"10.0.0.0/8"}},
+			}, nil},
+		{"Adjacent", []config.Element{{Network: "10.0.0.0/25"}},
+			config.Change{Networks: []string{"10.0.0.128/25", "10.0.0.200", "10.0.1.0/24"}},
+			[]config.Change{
+				{Networks: []string{"10.0.0.0/25"}},
+				{Add: true, Networks: []string{"10.0.0.0/23"}},
+			}, nil},
+		{"Present", []config.Element{{Network: "10.0.0.0/24"}},
+			config.Change{Networks: []string{"10.0.0.1", "10.0.0.0/25"}},
+			nil, nil},
+		{"Timeout", []config.Element{{Network: "10.0.0.0/24"}},
+			config.Change{Networks: []string{"10.0.0.1", "10.0.1.1"}, Timeout: time.Hour},
+			[]config.Change{{Add: true, Networks: []string{"10.0.1.1"}, Timeout: time.Hour}}, nil},
+		{"Absorb", []config.Element{{Network: "10.0.0.1", Expires: time.Minute}},
+			config.Change{Networks: []string{"10.0.0.0/24"}, Comment: "scan"},
+			[]config.Change{
+				{Networks: []string{"10.0.0.1"}},
+				{Add: true, Networks: []string{"10.0.0.0/24"}, Comment: "scan"},
+			}, nil},
+		{"Refresh", []config.Element{{Network: "10.0.0.0/30", Expires: time.Minute, Comment: "scan"}},
+			config.Change{Networks: []string{"10.0.0.1", "10.0.0.2"}, Timeout: time.Hour},
+			[]config.Change{
+				{Networks: []string{"10.0.0.0/30"}},
+				{Add: true, Networks: []string{"10.0.0.0", "10.0.0.3"}, Timeout: time.Minute, Comment: "scan"},
+				{Add: true, Networks: []string{"10.0.0.1-10.0.0.2"}, Timeout: time.Hour},
+			}, nil},
+		{"NoRefresh", []config.Element{{Network: "10.0.0.1", Expires: time.Hour}},
+			config.Change{Networks: []string{"10.0.0.1"}, Timeout: time.Minute},
+			nil, nil},
+		{"Overlap", []config.Element{{Network: "10.0.0.0/24", Comment: "office"}},
+			config.Change{Networks: []string{"10.0.0.128-10.0.1.10"}},
+			nil, ErrOverlap},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockNFT := new(MockNFT)
+			fw := &Firewall{config: cfg, handler: mockNFT}
+			mockNFT.On("ListElements", "test_accept").Return([]config.Element{}, nil).Maybe()
+			mockNFT.On("ListElements", "test_set").Return(tt.elements, nil)
+
+			if tt.want != nil {
+				mockNFT.On("Apply", tt.want).Return(nil)
+			}
+
+			err := fw.AddChange(tt.change)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			mockNFT.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	mockNFT := new(MockNFT)
+	ccfg := cfg
+	ccfg.Sets = []config.SetSpec{
+		{Name: "ssh", Rule: config.Rule{Protocol: config.ProtocolTCP, Ports: []uint16{22, 2222}, Verdict: config.VerdictReject}, Priority: -1},
+		{Name: "inspect", Rule: config.Rule{Match: config.MatchDst, Verdict: config.VerdictJump, Jump: "inspect"}, Priority: 1},
+	}
+	fw := &Firewall{config: ccfg, handler: mockNFT}
+
+	mockNFT.On("ListElements", "ssh").Return([]config.Element{{Network: "198.51.100.0/24"}}, nil)
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{{Network: "203.0.113.0/24"}}, nil)
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{
+		{Network: "203.0.113.7", Comment: "scan"},
+		{Network: "198.51.100.10-198.51.100.20", Expires: time.Minute},
+		{Network: "2001:db8::/32"},
+	}, nil)
+	mockNFT.On("ListElements", "inspect").Return([]config.Element{{Network: "192.0.2.0/24"}}, nil)
+
+	checks, err := fw.Check([]string{"203.0.113.7", "203.0.113.8", "198.51.100.15", "2001:db8::1", "192.0.2.1", "192.0.3.1"})
+	assert.NoError(t, err)
+
+	var buf strings.Builder
+	assert.NoError(t, WriteChecks(&buf, OutputPlain, checks))
+	assert.Equal(t, `203.0.113.7 accept
+  test_accept (accept): 203.0.113.0/24
+  test_set (drop): 203.0.113.7 # scan
+203.0.113.8 accept
+  test_accept (accept): 203.0.113.0/24
+198.51.100.15 reject
+  ssh (reject, tcp dport 22,2222): 198.51.100.0/24
+  test_set (drop): 198.51.100.10-198.51.100.20 (expires in 1m0s)
+2001:db8::1 drop
+  test_set (drop): 2001:db8::/32
+192.0.2.1 jump
+  inspect (jump inspect, dst): 192.0.2.0/24
+192.0.3.1 none
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, WriteChecks(&buf, OutputCSV, checks[2:4]))
+	assert.Equal(t, `address,status,set,rule,network,expires,comment
+198.51.100.15,reject,ssh,"reject, tcp dport 22,2222",198.51.100.0/24,,
+198.51.100.15,reject,test_set,drop,198.51.100.10-198.51.100.20,1m0s,
+2001:db8::1,drop,test_set,drop,2001:db8::/32,,
+`, buf.String())
+
+	_, err = fw.Check([]string{"10.0.0.0/8"})
+	assert.Error(t, err)
+}
+
+func TestLint(t *testing.T) {
+	mockNFT := new(MockNFT)
+	lcfg := cfg
+	lcfg.Sets = []config.SetSpec{{Name: "scanners", Priority: 1}}
+	fw := &Firewall{config: lcfg, handler: mockNFT}
+
+	mockNFT.On("ListElements", "test_accept").Return([]config.Element{{Network: "10.0.0.5"}}, nil)
+	mockNFT.On("ListElements", "test_set").Return([]config.Element{
+		{Network: "10.0.0.0/24"},
+		{Network: "192.0.2.0/24"},
+		{Network: "192.0.2.7"},
+	}, nil)
+	mockNFT.On("ListElements", "scanners").Return([]config.Element{{Network: "192.0.2.9"}, {Network: "198.51.100.1"}}, nil)
+
+	findings, err := fw.Lint()
+	assert.NoError(t, err)
+
+	var buf strings.Builder
+	assert.NoError(t, WriteFindings(&buf, OutputPlain, findings))
+	assert.Equal(t, `conflict: test_set 10.0.0.0/24 overlaps test_accept 10.0.0.5
+subsumed: test_set 192.0.2.7 covered by test_set 192.0.2.0/24
+shadowed: scanners 192.0.2.9 covered by test_set 192.0.2.0/24
+`, buf.String())
+
+	// предварительная проверка add
+	mockNFT.On("Apply", mock.Anything).Return(nil).Once()
+	assert.NoError(t, fw.Add("test_accept", []string{"10.0.0.128/25"}))
+
+	fw.config.Strict = true
+	assert.ErrorIs(t, fw.Add("test_accept", []string{"10.0.0.128/25"}), ErrConflict)
+	assert.ErrorIs(t, fw.Add("scanners", []string{"10.0.0.4/30"}), ErrConflict)
+	mockNFT.On("Apply", mock.Anything).Return(nil).Once()
+	assert.NoError(t, fw.Add("scanners", []string{"198.51.100.2"}))
+	mockNFT.AssertExpectations(t)
+}
@@ -1,6 +1,13 @@
 package ipset
 
-import "github.com/lrh3321/ipset-go"
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/lrh3321/ipset-go"
+)
 
 type IPS interface {
 	Create(setname, typename string, options ipset.CreateOptions) error
@@ -8,4 +15,60 @@ type IPS interface {
 	Del(setname string, element *ipset.Entry) error
 	List(setname string) (*ipset.Sets, error)
 	Destroy(setname string) error
+	Swap(from, to string) error
+	Flush(setname string) error
+	Copy(from, to string) error
+}
+
+// Handle дополняет netlink-соединение ipset копированием сетов.
+type Handle struct {
+	*ipset.Handle
+}
+
+// Copy копирует элементы сета from в сет to вместе с таймаутами и комментариями
+// одной командой ipset restore, без отдельного запроса на каждый элемент.
+// ipset save from | sed -n 's/^add from /add to /p' | ipset restore -exist
+func (h Handle) Copy(from, to string) error {
+	var stderr bytes.Buffer
+
+	save := exec.Command("ipset", "save", from)
+	save.Stderr = &stderr
+
+	out, err := save.Output()
+	if err != nil {
+		return fmt.Errorf("ipset save %s: %w: %s", from, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var entries bytes.Buffer
+
+	for line := range strings.Lines(string(out)) {
+		if entry, ok := copyEntry(line, from, to); ok {
+			entries.WriteString(entry + "\n")
+		}
+	}
+
+	restore := exec.Command("ipset", "restore", "-exist")
+	restore.Stdin = &entries
+
+	if out, err := restore.CombinedOutput(); err != nil {
+		return fmt.Errorf("ipset restore %s: %w: %s", to, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// copyEntry возвращает строку ipset restore, которая добавит в сет to элемент из строки ipset save сета from.
+// Элементу без таймаута добавляется timeout 0, иначе в сете с таймаутами он получит таймаут по умолчанию.
+func copyEntry(line, from, to string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), "add "+from+" ")
+	if !ok {
+		return "", false
+	}
+
+	// комментарий выводится последним и может содержать любые слова
+	if attrs, _, _ := strings.Cut(rest, ` comment "`); !strings.Contains(attrs, " timeout ") {
+		rest += " timeout 0"
+	}
+
+	return "add " + to + " " + rest, true
 }
@@ -1,11 +1,19 @@
 package ipset
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lrh3321/ipset-go"
+	"golang.org/x/sys/unix"
 
 	"github.com/LeKovr/fwset/config"
 	"github.com/LeKovr/fwset/utils"
@@ -15,61 +23,746 @@ type Config struct {
 	config.Config
 }
 
+// MaxTimeout - максимальный таймаут ipset в секундах.
+// Таймаут 0 при создании сета не передается, поэтому по умолчанию задается максимальный.
+const MaxTimeout = 2147483
+
+// ErrTimeoutRange возвращается, если таймаут элемента больше MaxTimeout.
+var ErrTimeoutRange = errors.New("timeout exceeds ipset maximum")
+
+// LockFile - файл блокировки, который не дает параллельным изменениям использовать одни временные сеты.
+const LockFile = "/run/fwset.ipset.lock"
+
 type FireWall struct {
-	config config.Config
-	conn   IPS
+	config   config.Config
+	conn     IPS
+	rules    IPT
+	lockFile string // пустой в режиме dry run
 }
 
 func New(cfg config.Config) (*FireWall, error) {
-	conn, err := ipset.NewHandle()
+	handle, err := ipset.NewHandle()
 	if err != nil {
 		return nil, err
 	}
 
-	return &FireWall{
+	conn := Handle{handle}
+
+	fw := &FireWall{
 		config: cfg,
 		conn:   conn,
-	}, nil
+		rules:  IPTables{},
+	}
+
+	if cfg.DryRun {
+		fw.conn = NewRecorder(conn, os.Stdout)
+		fw.rules = NewRuleRecorder(fw.rules, os.Stdout)
+	} else {
+		fw.lockFile = LockFile
+	}
+
+	return fw, nil
 }
 
-func (fw *FireWall) Create(accept bool) error {
-	// iptables -I INPUT -m set --match-set fedeban-ip-on src -j ACCEPT
-	// iptables -I INPUT -m set --match-set fedeban-net-off src -j DROP
+// families задает суффикс имени сета для каждого семейства адресов.
+var families = []struct {
+	suffix string
+	family uint8
+}{
+	{"", ipset.FamilyIPV4},
+	{config.SetSuffixIPv6, ipset.FamilyIPV6},
+}
+
+func (fw *FireWall) Create(spec config.SetSpec) error {
 	conn := fw.conn
-	name := fw.setName(accept)
+	name := spec.Name
 
-	err := conn.Create(name, ipset.TypeHashNet, ipset.CreateOptions{
-		Replace: true,
-	}) // ipset create bad_nets_n hash:net hashsize 4096 maxelem 262144
+	if err := spec.Validate(); err != nil {
+		return err
+	}
 
-	return err
+	// правила iptables добавляются в цепочку --chain, параметры цепочки nftables к ним не применимы
+	if opts := fw.config.NFTOnlyOptions(); len(opts) > 0 {
+		return fmt.Errorf("%w: %s", config.ErrNFTOnly, strings.Join(opts, ", "))
+	}
+
+	for _, fam := range families {
+		if err := fw.migrate(name+fam.suffix, fam.family); err != nil {
+			return err
+		}
+
+		options := setOptions(fam.family)
+		options.Replace = true
+
+		// ipset create bad_nets_n hash:net family inet6 timeout 2147483 comment -exist
+		if err := conn.Create(name+fam.suffix, ipset.TypeHashNet, options); err != nil {
+			return err
+		}
+
+		if fw.config.NoRules {
+			slog.Info("Rule not added, reference the set in your chain", "set", name+fam.suffix)
+
+			continue
+		}
+
+		// iptables -A INPUT -m set --match-set allowed_nets src -j LOG --log-level 4
+		// ip6tables -A INPUT -m set --match-set blocked_nets6 src -j DROP
+		ipv6 := fam.family == ipset.FamilyIPV6
+
+		if err := fw.syncRules(name+fam.suffix, ipv6, spec.Rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncRules добавляет правила сета. Если правила сета в цепочке отличаются от настроек
+// (например, после изменения правила или созданы без метки), они удаляются и добавляются заново.
+func (fw *FireWall) syncRules(setName string, ipv6 bool, spec config.Rule) error {
+	chain := chainName(fw.config.ChainName)
+	want := matchRules(setName, ipv6, spec)
+
+	existing, err := fw.setRules(ipv6, setName)
+	if err != nil {
+		return err
+	}
+
+	same := len(existing) == len(want)
+
+	for _, rule := range want {
+		if !same {
+			break
+		}
+
+		if same, err = fw.rules.Exists(ipv6, chain, rule...); err != nil {
+			return err
+		}
+	}
+
+	if same {
+		return nil
+	}
+
+	if len(existing) > 0 {
+		slog.Info("Rules replaced", "set", setName)
+	}
+
+	for _, rule := range slices.Backward(existing) {
+		if err := fw.rules.Delete(ipv6, chain, rule...); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range want {
+		if err := fw.addRule(setName, ipv6, rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setRules возвращает правила цепочки с меткой сета или проверкой адреса по нему
+// (правила, созданные без метки).
+func (fw *FireWall) setRules(ipv6 bool, setName string) ([][]string, error) {
+	rules, err := fw.rules.ListRules(ipv6, chainName(fw.config.ChainName))
+	if err != nil {
+		return nil, err
+	}
+
+	tag := ruleTag(setName)
+
+	return slices.DeleteFunc(rules, func(rule []string) bool {
+		for i := 1; i < len(rule); i++ {
+			if rule[i-1] == "--comment" && rule[i] == tag || rule[i-1] == "--match-set" && rule[i] == setName {
+				return false
+			}
+		}
+
+		return true
+	}), nil
+}
+
+// migrate пересоздает сет без поддержки таймаутов или комментариев (созданный прежними версиями):
+// элементы копируются во временный сет с нужными параметрами, который затем подменяет сет.
+func (fw *FireWall) migrate(setName string, family uint8) error {
+	list, err := fw.conn.List(setName)
+	if notExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if list.Timeout != nil && list.CadtFlags&ipset.IPSET_FLAG_WITH_COMMENT != 0 {
+		return nil
+	}
+
+	unlock, err := fw.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	slog.Info("Set recreated with timeout and comment support", "set", setName)
+
+	return fw.swap([]string{setName}, map[string]*setOps{setName: {family: family}}, true, nil)
+}
+
+// notExist возвращает true для ошибки отсутствия сета.
+func notExist(err error) bool {
+	return errors.Is(err, ipset.ErrSetNotExist) || errors.Is(err, os.ErrNotExist)
+}
+
+// setOptions возвращает параметры создания сета для семейства адресов.
+// Таймаут по умолчанию включает поддержку таймаутов элементов. ipset-go не передает таймаут 0,
+// поэтому fwset добавляет постоянные элементы с явным timeout 0, а элементы, добавленные
+// без таймаута сторонними утилитами, удаляются через MaxTimeout секунд.
+func setOptions(family uint8) ipset.CreateOptions {
+	return ipset.CreateOptions{
+		Family:   family,
+		Timeout:  MaxTimeout,
+		Comments: true,
+	}
 }
 
+// addRule добавляет правило сета в позицию из настроек: в конец цепочки (last), в начало (first) или по номеру.
+// По умолчанию правила вставляются в начало, иначе ACCEPT и RETURN, которые обычно уже есть в INPUT,
+// сработают раньше и сеты drop не будут проверяться.
+// Позиция вставки уточняется по правилам fwset в цепочке, см. rulePosition.
+func (fw *FireWall) addRule(setName string, ipv6 bool, rule []string) error {
+	chain := chainName(fw.config.ChainName)
+
+	pos, err := fw.config.PositionHandle()
+	if err != nil {
+		return err
+	}
+
+	switch fw.config.Position {
+	case "", config.PositionFirst:
+		pos = 1
+	case config.PositionLast:
+		return fw.rules.Append(ipv6, chain, rule...)
+	}
+
+	at, err := fw.rulePosition(setName, ipv6, int(pos)) //nolint:gosec // номер правила задается в настройках
+	if err != nil {
+		return err
+	}
+
+	return fw.rules.Insert(ipv6, chain, at, rule...)
+}
+
+// rulePosition возвращает номер, под которым нужно вставить правило сета:
+// перед первым правилом сета, который в SetSpecs идет после него, иначе не раньше pos
+// и после правил этого сета и сетов, которые идут перед ним.
+// Так порядок приоритета сохраняется, даже если сеты создавались разными запусками.
+func (fw *FireWall) rulePosition(setName string, ipv6 bool, pos int) (int, error) {
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
+		return 0, err
+	}
+
+	rank := make(map[string]int) // метка правила - приоритет сета
+	for i, spec := range specs {
+		for _, fam := range families {
+			rank[ruleTag(spec.Name+fam.suffix)] = i
+		}
+	}
+
+	own, ok := rank[ruleTag(setName)]
+	if !ok {
+		return pos, nil
+	}
+
+	rules, err := fw.rules.ListRules(ipv6, chainName(fw.config.ChainName))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, rule := range rules {
+		r, ok := rank[ruleComment(rule)]
+		if !ok {
+			continue
+		}
+
+		if r > own {
+			return i + 1, nil
+		}
+
+		pos = max(pos, i+2)
+	}
+
+	return pos, nil
+}
+
+// ruleComment возвращает комментарий правила iptables.
+func ruleComment(rule []string) string {
+	for i := 1; i < len(rule); i++ {
+		if rule[i-1] == "--comment" {
+			return rule[i]
+		}
+	}
+
+	return ""
+}
+
+// Destroy удаляет правила и сеты, заданные в настройках, и оставшиеся временные сеты.
 func (fw *FireWall) Destroy() error {
 	conn := fw.conn
-	if err := conn.Destroy(fw.config.SetNameAccept); err != nil {
+
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := fw.lock()
+	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	for _, spec := range specs {
+		name := spec.Name
+
+		for _, fam := range families {
+			// пока сет используется в правиле, его нельзя удалить
+			ipv6 := fam.family == ipset.FamilyIPV6
+
+			rules, err := fw.setRules(ipv6, name+fam.suffix)
+			if err != nil {
+				return err
+			}
+
+			for _, rule := range slices.Backward(rules) {
+				if err := fw.rules.Delete(ipv6, chainName(fw.config.ChainName), rule...); err != nil {
+					return err
+				}
+			}
+
+			if err := conn.Destroy(name + fam.suffix); err != nil {
+				return err
+			}
+
+			// временный сет остается после аварийного завершения Apply
+			if err := conn.Destroy(name + fam.suffix + TxSuffix); err != nil && !notExist(err) {
+				return err
+			}
+		}
+	}
 
-	return conn.Destroy(fw.config.SetNameDrop)
+	return nil
 }
 
-func (fw *FireWall) Modify(accept, add bool, networks []string) error {
-	conn := fw.conn
-	name := fw.setName(accept)
+// rejectTypes - типы REJECT для iptables и ip6tables.
+var rejectTypes = map[string][2]string{
+	config.RejectPortUnreachable: {"icmp-port-unreachable", "icmp6-port-unreachable"},
+	config.RejectHostUnreachable: {"icmp-host-unreachable", "icmp6-addr-unreachable"},
+	config.RejectNoRoute:         {"icmp-net-unreachable", "icmp6-no-route"},
+	config.RejectAdminProhibited: {"icmp-admin-prohibited", "icmp6-adm-prohibited"},
+	config.RejectTCPReset:        {"tcp-reset", "tcp-reset"},
+}
 
-	for _, network := range networks {
-		entry, err := CIDRToEntry(network)
-		if err != nil {
+// RuleTagPrefix - префикс комментария правил, созданных fwset.
+const RuleTagPrefix = "fwset:"
+
+// ruleTag возвращает комментарий, которым помечаются правила сета.
+func ruleTag(setName string) string {
+	return RuleTagPrefix + setName
+}
+
+// matchRules возвращает правила iptables для проверки адреса источника или назначения по сету.
+// LOG не завершает обработку пакета, поэтому логирование выполняется отдельным правилом перед вердиктом.
+// Счетчики пакетов в iptables есть у каждого правила, поэтому NoCounter не учитывается.
+// Правила помечаются комментарием fwset:<set>, по которому Destroy находит их в цепочке.
+func matchRules(setName string, ipv6 bool, spec config.Rule) [][]string {
+	match := matchArgs(setName, ipv6, spec)
+
+	var rv [][]string
+
+	if !spec.NoLog {
+		rule := slices.Clone(match)
+		if spec.LogRate != "" {
+			rule = append(rule, "-m", "limit", "--limit", spec.LogRate)
+		}
+
+		rule = append(rule, "-j", "LOG", "--log-level", strconv.Itoa(spec.Level()))
+		if spec.LogPrefix != "" {
+			rule = append(rule, "--log-prefix", spec.LogPrefix)
+		}
+
+		rv = append(rv, rule)
+	}
+
+	rule := slices.Clone(match)
+
+	switch spec.Verdict {
+	case config.VerdictAccept:
+		rule = append(rule, "-j", "ACCEPT")
+	case config.VerdictReject:
+		if spec.RejectWith == config.RejectTCPReset && spec.Protocol == "" {
+			rule = append(rule, "-p", "tcp")
+		}
+
+		family := 0
+		if ipv6 {
+			family = 1
+		}
+
+		rule = append(rule, "-j", "REJECT", "--reject-with", rejectTypes[spec.RejectWith][family])
+	case config.VerdictJump:
+		rule = append(rule, "-j", spec.Jump) // цепочка должна существовать
+	default:
+		rule = append(rule, "-j", "DROP")
+	}
+
+	return append(rv, rule)
+}
+
+// matchArgs возвращает условия правила: сет, метку, протокол и порты назначения.
+func matchArgs(setName string, ipv6 bool, spec config.Rule) []string {
+	dir := spec.Match
+	if dir == "" {
+		dir = config.MatchSrc
+	}
+
+	rv := []string{"-m", "set", "--match-set", setName, dir, "-m", "comment", "--comment", ruleTag(setName)}
+
+	switch {
+	case spec.Protocol == "":
+		return rv
+	case spec.Protocol == config.ProtocolICMP && ipv6:
+		return append(rv, "-p", "ipv6-icmp")
+	default:
+		rv = append(rv, "-p", spec.Protocol)
+	}
+
+	ports := make([]string, len(spec.Ports))
+	for i, port := range spec.Ports {
+		ports[i] = strconv.Itoa(int(port))
+	}
+
+	switch len(ports) {
+	case 0:
+		return rv
+	case 1:
+		return append(rv, "-m", spec.Protocol, "--dport", ports[0])
+	default:
+		return append(rv, "-m", "multiport", "--dports", strings.Join(ports, ","))
+	}
+}
+
+func (fw *FireWall) Modify(set string, add bool, networks []string) error {
+	return fw.Apply([]config.Change{{Set: set, Add: add, Networks: networks}})
+}
+
+// TxSuffix - суффикс временного сета, в котором готовятся изменения.
+const TxSuffix = "_tx"
+
+// setOps - операции с элементами одного сета.
+type setOps struct {
+	family uint8
+	ops    []entryOp
+}
+
+// mixed возвращает true, если в сете есть и добавления, и удаления.
+func (s *setOps) mixed() bool {
+	return slices.ContainsFunc(s.ops, func(op entryOp) bool { return op.add }) &&
+		slices.ContainsFunc(s.ops, func(op entryOp) bool { return !op.add })
+}
+
+// entryOp - добавление или удаление элемента сета.
+type entryOp struct {
+	add   bool
+	entry *ipset.Entry
+}
+
+// Apply выполняет изменения так, что каждый сет меняется целиком или не меняется совсем.
+// Сет, в котором есть и добавления, и удаления, копируется во временный (ipset save/restore),
+// изменения применяются к копии, затем копия меняется местами с сетом (ipset swap).
+// Остальные сеты меняются напрямую, а при ошибке выполненные операции отменяются.
+// Параллельные изменения ждут блокировку LockFile, поэтому временный сет, оставшийся
+// после аварийного завершения, просто очищается.
+func (fw *FireWall) Apply(changes []config.Change) error {
+	names, sets, err := fw.prepare(changes)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := fw.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var swapped, direct []string
+
+	for _, name := range names {
+		if sets[name].mixed() {
+			swapped = append(swapped, name)
+		} else {
+			direct = append(direct, name)
+		}
+	}
+
+	return fw.swap(swapped, sets, true, direct)
+}
+
+// Replace заменяет содержимое сетов IPv4 и IPv6 сетями изменения.
+// Сети добавляются в пустые временные сеты, которые затем подменяют сеты (ipset swap).
+func (fw *FireWall) Replace(change config.Change) error {
+	change.Add = true
+
+	names, sets, err := fw.prepare([]config.Change{change})
+	if err != nil {
+		return err
+	}
+
+	for _, fam := range families {
+		setName := fw.config.ChangeSet(change) + fam.suffix
+		if _, ok := sets[setName]; !ok {
+			names = append(names, setName)
+			sets[setName] = &setOps{family: fam.family}
+		}
+	}
+
+	unlock, err := fw.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fw.swap(names, sets, false, nil)
+}
+
+// swap готовит временные сеты с операциями sets и меняет их местами с исходными.
+// Если keep == true, во временный сет сначала копируется содержимое исходного.
+// Операции сетов direct выполняются напрямую после подготовки временных сетов.
+// При ошибке уже подмененные сеты меняются обратно, а операции direct отменяются.
+func (fw *FireWall) swap(names []string, sets map[string]*setOps, keep bool, direct []string) error {
+	var (
+		created []string
+		undo    []undoOp
+		err     error
+	)
+
+	for _, name := range names {
+		tmp := name + TxSuffix
+
+		options := setOptions(sets[name].family)
+		options.Replace = true // сет мог остаться после аварийного завершения
+
+		if err = fw.conn.Create(tmp, ipset.TypeHashNet, options); err != nil {
+			err = fmt.Errorf("temporary set %s: %w", tmp, err)
+
+			break
+		}
+
+		created = append(created, tmp)
+
+		if err = fw.fillTmp(name, tmp, sets[name].ops, keep); err != nil {
+			err = fmt.Errorf("temporary set %s: %w", tmp, err)
+
+			break
+		}
+	}
+
+	if err == nil {
+		undo, err = fw.applyDirect(direct, sets)
+	}
+
+	if err == nil {
+		for i, name := range names {
+			if err = fw.conn.Swap(name+TxSuffix, name); err != nil {
+				for _, done := range slices.Backward(names[:i]) {
+					if e := fw.conn.Swap(done+TxSuffix, done); e != nil {
+						slog.Error("Set swap back failed", "set", done, "err", e)
+					}
+				}
+
+				fw.undo(undo)
+
+				break
+			}
+		}
+	}
+
+	for _, tmp := range created {
+		if e := fw.conn.Destroy(tmp); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// fillTmp очищает временный сет tmp и выполняет в нем операции ops сета name.
+// Если keep == true, в сет сначала копируется содержимое name.
+func (fw *FireWall) fillTmp(name, tmp string, ops []entryOp, keep bool) error {
+	if err := fw.conn.Flush(tmp); err != nil {
+		return err
+	}
+
+	if keep {
+		if err := fw.conn.Copy(name, tmp); err != nil {
 			return err
 		}
-		// fmt.Printf("Add: %+v\n", entry)
-		// Equivalent to: `ipset add hash01 10.0.0.1`
-		// err = ipset.Add(setname, &ipset.Entry{IP: net.IPv4(10, 0, 0, 1).To4()})
-		if add {
-			err = conn.Add(name, entry)
+	}
+
+	return fw.apply(tmp, ops)
+}
+
+// lock ждет блокировку LockFile и возвращает функцию ее снятия.
+func (fw *FireWall) lock() (func(), error) {
+	if fw.lockFile == "" {
+		return func() {}, nil
+	}
+
+	f, err := os.OpenFile(fw.lockFile, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil { //nolint:gosec // дескриптор файла помещается в int
+		f.Close()
+
+		return nil, fmt.Errorf("lock %s: %w", fw.lockFile, err)
+	}
+
+	return func() { f.Close() }, nil // закрытие файла снимает блокировку
+}
+
+// undoOp - операция, отменяющая изменение элемента сета.
+type undoOp struct {
+	setName string
+	entryOp
+}
+
+// applyDirect выполняет операции сетов names напрямую и возвращает операции для их отмены.
+// Если изменений больше одного, прежние элементы читаются заранее, чтобы восстановить
+// их таймауты и комментарии. При ошибке выполненные операции отменяются.
+func (fw *FireWall) applyDirect(names []string, sets map[string]*setOps) ([]undoOp, error) {
+	total := 0
+	for _, name := range names {
+		total += len(sets[name].ops)
+	}
+
+	var undo []undoOp
+
+	for _, name := range names {
+		prev := make(map[string]ipset.Entry)
+
+		if total > 1 {
+			// в режиме dry run сет мог быть еще не создан
+			list, err := fw.conn.List(name)
+			if err != nil && !notExist(err) {
+				return nil, err
+			}
+
+			if list != nil {
+				for _, e := range list.Entries {
+					prev[EntryToCIDR(e)] = e
+				}
+			}
+		}
+
+		for _, op := range sets[name].ops {
+			if err := fw.apply(name, []entryOp{op}); err != nil {
+				fw.undo(undo)
+
+				return nil, err
+			}
+
+			if total > 1 {
+				undo = append(undo, undoOp{name, restoreOp(prev, op)})
+			}
+		}
+	}
+
+	return undo, nil
+}
+
+// restoreOp возвращает операцию, которая вернет элемент op в прежнее состояние.
+func restoreOp(prev map[string]ipset.Entry, op entryOp) entryOp {
+	e, ok := prev[EntryToCIDR(*op.entry)]
+	if !ok {
+		return entryOp{add: false, entry: op.entry}
+	}
+
+	var timeout uint32 // элемент без таймаута должен остаться постоянным
+	if e.Timeout != nil {
+		timeout = *e.Timeout
+	}
+
+	return entryOp{add: true, entry: &ipset.Entry{IP: e.IP, CIDR: e.CIDR, Timeout: &timeout, Comment: e.Comment, Replace: true}}
+}
+
+// undo отменяет операции в обратном порядке, ошибки отмены логируются.
+func (fw *FireWall) undo(ops []undoOp) {
+	for _, op := range slices.Backward(ops) {
+		if err := fw.apply(op.setName, []entryOp{op.entryOp}); err != nil {
+			slog.Error("Set change rollback failed", "set", op.setName, "err", err)
+		}
+	}
+}
+
+// prepare разбирает сети изменений и группирует операции по сетам.
+// Ошибка в любой сети возвращается до изменения сетов.
+func (fw *FireWall) prepare(changes []config.Change) ([]string, map[string]*setOps, error) {
+	var names []string // порядок сетов для воспроизводимости
+
+	sets := make(map[string]*setOps)
+
+	for _, change := range changes {
+		name := fw.config.ChangeSet(change)
+
+		if change.Timeout > MaxTimeout*time.Second {
+			return nil, nil, fmt.Errorf("%w: %s > %ds", ErrTimeoutRange, change.Timeout, MaxTimeout)
+		}
+
+		// сеты созданы с таймаутом по умолчанию, поэтому для постоянных элементов явно передаем 0
+		timeout := uint32(math.Ceil(change.Timeout.Seconds()))
+
+		for _, network := range change.Networks {
+			entries, err := CIDRToEntries(network)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for _, entry := range entries {
+				setName, family := name, uint8(ipset.FamilyIPV4)
+				if entry.IP.To4() == nil {
+					setName, family = name+config.SetSuffixIPv6, ipset.FamilyIPV6
+				}
+
+				if change.Add {
+					entry.Timeout = &timeout
+					entry.Comment = change.Comment
+				}
+
+				if _, ok := sets[setName]; !ok {
+					names = append(names, setName)
+					sets[setName] = &setOps{family: family}
+				}
+
+				sets[setName].ops = append(sets[setName].ops, entryOp{add: change.Add, entry: entry})
+			}
+		}
+	}
+
+	return names, sets, nil
+}
+
+// apply выполняет операции с элементами сета.
+func (fw *FireWall) apply(setName string, ops []entryOp) error {
+	for _, op := range ops {
+		var err error
+		if op.add {
+			err = fw.conn.Add(setName, op.entry)
 		} else {
-			err = conn.Del(name, entry)
+			err = fw.conn.Del(setName, op.entry)
 		}
 
 		if err != nil {
@@ -80,50 +773,110 @@ func (fw *FireWall) Modify(accept, add bool, networks []string) error {
 	return nil
 }
 
-func (r *FireWall) Add(accept bool, networks []string) error {
-	return r.Modify(accept, true, networks)
+func (r *FireWall) Add(set string, networks []string) error {
+	return r.Modify(set, true, networks)
 }
 
-func (r *FireWall) Remove(accept bool, networks []string) error {
-	return r.Modify(accept, false, networks)
+func (r *FireWall) Remove(set string, networks []string) error {
+	return r.Modify(set, false, networks)
 }
 
-func (fw *FireWall) List(accept bool) ([]string, error) {
-	conn := fw.conn
-	// List the set.
-	set, err := conn.List(fw.setName(accept))
+func (fw *FireWall) List(set string) ([]string, error) {
+	elements, err := fw.ListElements(set)
 	if err != nil {
 		return nil, err
 	}
 
-	rv := make([]string, len(set.Entries))
+	networks := make([]string, len(elements))
+	for i, elem := range elements {
+		networks[i] = elem.Network
+	}
+
+	return networks, nil
+}
+
+// ListElements возвращает элементы IPv4 и IPv6 сетов.
+// Если задан ListRanges, смежные постоянные элементы без комментария объединяются в диапазоны.
+func (fw *FireWall) ListElements(name string) ([]config.Element, error) {
+	conn := fw.conn
+
+	var rv []config.Element
+
+	for _, fam := range families {
+		// List the set.
+		list, err := conn.List(name + fam.suffix)
+		if err != nil {
+			return nil, err
+		}
+
+		ranges := make([]utils.IPRange, 0, len(list.Entries))
+
+		for _, e := range list.Entries {
+			elem := config.Element{Network: EntryToCIDR(e), Comment: e.Comment}
+			if e.Timeout != nil {
+				elem.Expires = time.Duration(*e.Timeout) * time.Second
+			}
+
+			if !fw.config.ListRanges || elem.Expires != 0 || elem.Comment != "" {
+				rv = append(rv, elem)
+
+				continue
+			}
+
+			r, err := utils.ParseRange(elem.Network)
+			if err != nil {
+				return nil, err
+			}
 
-	for i, e := range set.Entries {
-		network := e.IP.String()
-		if e.CIDR != 32 {
-			network = fmt.Sprintf("%s/%d", network, e.CIDR)
+			ranges = append(ranges, r)
 		}
 
-		rv[i] = network
+		for _, r := range utils.CollapseRanges(ranges) {
+			rv = append(rv, config.Element{Network: r.String()})
+		}
 	}
 
 	return rv, nil
 }
 
-func (fw *FireWall) setName(is_accept bool) string {
-	if is_accept {
-		return fw.config.SetNameAccept
+// CIDRToEntries возвращает элементы сета для сети или диапазона.
+// Диапазон вида a-b раскладывается в минимальный набор покрывающих его сетей.
+func CIDRToEntries(network string) ([]*ipset.Entry, error) {
+	if !strings.Contains(network, "-") {
+		entry, err := CIDRToEntry(network)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*ipset.Entry{entry}, nil
 	}
 
-	return fw.config.SetNameDrop
+	firstIP, lastIP, err := utils.CIDRToRange(network)
+	if err != nil {
+		return nil, err
+	}
+
+	nets, err := utils.IPRangeToCIDR(nil, firstIP.String(), lastIP.String())
+	if err != nil {
+		return nil, err
+	}
+
+	rv := make([]*ipset.Entry, len(nets))
+	for i, n := range nets {
+		if rv[i], err = CIDRToEntry(n); err != nil {
+			return nil, err
+		}
+	}
+
+	return rv, nil
 }
 
 // &ipset.Entry{IP: net.IPv4(176, 123, 165, 0).To4()}
 
 func CIDRToEntry(network string) (*ipset.Entry, error) {
 	if strings.Contains(network, "-") {
-		// для этого нужен отдельный set типа hash:net,net
-		return nil, fmt.Errorf("IP range not implemented")
+		// диапазон обрабатывается в CIDRToEntries
+		return nil, fmt.Errorf("IP range is not a network: %s", network)
 	}
 
 	ipnet, err := utils.ParseNetwork(network) // добавим маску, если не было
@@ -140,5 +893,24 @@ func CIDRToEntry(network string) (*ipset.Entry, error) {
 
 	uint8Value := uint8(uint64Value)
 
-	return &ipset.Entry{IP: ipnet.IP.To4(), CIDR: uint8Value, Replace: true}, nil
+	ip := ipnet.IP.To4()
+	if ip == nil {
+		ip = ipnet.IP.To16()
+	}
+
+	return &ipset.Entry{IP: ip, CIDR: uint8Value, Replace: true}, nil
+}
+
+// EntryToCIDR возвращает сеть элемента сета, для адреса хоста - без маски.
+func EntryToCIDR(e ipset.Entry) string {
+	bits := net.IPv6len * 8
+	if e.IP.To4() != nil {
+		bits = net.IPv4len * 8
+	}
+
+	if int(e.CIDR) == bits {
+		return e.IP.String()
+	}
+
+	return fmt.Sprintf("%s/%d", e.IP, e.CIDR)
 }
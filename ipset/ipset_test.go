@@ -1,10 +1,14 @@
 package ipset
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lrh3321/ipset-go"
 
@@ -21,20 +25,85 @@ var cfg = config.Config{
 // MockNFTConn для тестирования без реального взаимодействия с nftables
 type MockConn struct {
 	Elements map[string][]ipset.Entry
+	Rules    []string
+	Options  map[string]ipset.CreateOptions
+	Specs    map[string][]string // аргументы правил Rules
+	Full     string              // сет, в который нельзя добавить элементы
+	NoSwap   string              // сет, который нельзя поменять местами
 }
 
 func NewMockConn() *MockConn {
 	return &MockConn{
 		Elements: make(map[string][]ipset.Entry),
+		Options:  make(map[string]ipset.CreateOptions),
+		Specs:    make(map[string][]string),
 	}
 }
 
+func mockRule(ipv6 bool, chain string, rule []string) string {
+	return fmt.Sprintf("%v %s %s", ipv6, chain, strings.Join(rule, " "))
+}
+
+func (m *MockConn) Exists(ipv6 bool, chain string, rule ...string) (bool, error) {
+	return slices.Contains(m.Rules, mockRule(ipv6, chain, rule)), nil
+}
+
+func (m *MockConn) Append(ipv6 bool, chain string, rule ...string) error {
+	m.Rules = append(m.Rules, mockRule(ipv6, chain, rule))
+	m.Specs[mockRule(ipv6, chain, rule)] = rule
+	return nil
+}
+
+// ListRules возвращает правила цепочки, для добавленных без Append и Insert аргументы разделяются по пробелам.
+func (m *MockConn) ListRules(ipv6 bool, chain string) ([][]string, error) {
+	prefix := mockRule(ipv6, chain, nil)
+	var rv [][]string
+	for _, r := range m.Rules {
+		if rest, ok := strings.CutPrefix(r, prefix); ok {
+			spec, ok := m.Specs[r]
+			if !ok {
+				spec = strings.Fields(rest)
+			}
+			rv = append(rv, spec)
+		}
+	}
+	return rv, nil
+}
+
+// Insert вставляет правило перед pos-м правилом цепочки того же семейства.
+func (m *MockConn) Insert(ipv6 bool, chain string, pos int, rule ...string) error {
+	m.Specs[mockRule(ipv6, chain, rule)] = rule
+	prefix := mockRule(ipv6, chain, nil)
+	n := 0
+	for i, r := range m.Rules {
+		if strings.HasPrefix(r, prefix) {
+			if n++; n == pos {
+				m.Rules = slices.Insert(m.Rules, i, mockRule(ipv6, chain, rule))
+				return nil
+			}
+		}
+	}
+	m.Rules = append(m.Rules, mockRule(ipv6, chain, rule))
+	return nil
+}
+
+func (m *MockConn) Delete(ipv6 bool, chain string, rule ...string) error {
+	m.Rules = slices.DeleteFunc(m.Rules, func(r string) bool { return r == mockRule(ipv6, chain, rule) })
+	return nil
+}
+
 func (m *MockConn) Create(setname, typename string, options ipset.CreateOptions) error {
-	m.Elements[setname] = []ipset.Entry{}
+	if _, ok := m.Elements[setname]; !ok {
+		m.Elements[setname] = []ipset.Entry{}
+		m.Options[setname] = options
+	}
 	return nil
 }
 
 func (m *MockConn) Add(set string, element *ipset.Entry) error {
+	if set == m.Full {
+		return errors.New("hash is full")
+	}
 	m.Elements[set] = append(m.Elements[set], *element)
 	return nil
 }
@@ -51,15 +120,42 @@ func (m *MockConn) Del(set string, element *ipset.Entry) error {
 }
 
 func (m *MockConn) List(set string) (*ipset.Sets, error) {
-	return &ipset.Sets{Entries: m.Elements[set]}, nil
+	entries, ok := m.Elements[set]
+	if !ok {
+		return nil, ipset.ErrSetNotExist
+	}
+	options := m.Options[set]
+	rv := &ipset.Sets{Entries: entries, CadtFlags: options.CadtFlags()}
+	if options.Timeout != 0 {
+		rv.Timeout = &options.Timeout
+	}
+	return rv, nil
 }
 
 func (m *MockConn) Destroy(set string) error {
 	delete(m.Elements, set)
+	delete(m.Options, set)
+	return nil
+}
+
+func (m *MockConn) Swap(from, to string) error {
+	if to == m.NoSwap {
+		return errors.New("swap failed")
+	}
+	m.Elements[from], m.Elements[to] = m.Elements[to], m.Elements[from]
+	m.Options[from], m.Options[to] = m.Options[to], m.Options[from]
 	return nil
 }
 
-func (m *MockConn) Flush() error { return nil }
+func (m *MockConn) Copy(from, to string) error {
+	m.Elements[to] = append(m.Elements[to], m.Elements[from]...)
+	return nil
+}
+
+func (m *MockConn) Flush(set string) error {
+	m.Elements[set] = []ipset.Entry{}
+	return nil
+}
 
 func NewMockFW(cfg config.Config, mockConn *MockConn) *FireWall {
 	return &FireWall{
@@ -67,7 +163,8 @@ func NewMockFW(cfg config.Config, mockConn *MockConn) *FireWall {
 		//		tableName: "myfirewall",
 		//		chainName: "input",
 		//		setName:   "blocked_nets",
-		conn: mockConn,
+		conn:  mockConn,
+		rules: mockConn,
 	}
 }
 
@@ -76,11 +173,192 @@ func TestCreateBlocklist(t *testing.T) {
 	mockConn := NewMockConn()
 
 	nft := NewMockFW(cfg, mockConn)
-	nft.Create(false)
+	nft.Create(dropSpec(nft.config))
 
 	if _, ok := mockConn.Elements[cfg.SetNameDrop]; !ok {
 		t.Error("Set not created")
 	}
+	if _, ok := mockConn.Elements[cfg.SetNameDrop+config.SetSuffixIPv6]; !ok {
+		t.Error("IPv6 set not created")
+	}
+}
+
+func TestCreateRules(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(config.Config{
+		ChainName:     "input",
+		SetNameAccept: "test_accept",
+		SetNameDrop:   "test_drop",
+		RuleAccept:    config.Rule{NoLog: true},
+		RuleDrop:      config.Rule{LogPrefix: "fwset drop: ", LogLevel: "info", LogRate: "10/minute"},
+	}, mockConn)
+
+	assert.NoError(t, fw.Create(acceptSpec(fw.config)))
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+	// повторный вызов не дублирует правила
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	assert.Equal(t, []string{
+		"false INPUT -m set --match-set test_accept src -m comment --comment fwset:test_accept -j ACCEPT",
+		"true INPUT -m set --match-set test_accept6 src -m comment --comment fwset:test_accept6 -j ACCEPT",
+		"false INPUT -m set --match-set test_drop src -m comment --comment fwset:test_drop -m limit --limit 10/minute -j LOG --log-level 6 --log-prefix fwset drop: ",
+		"false INPUT -m set --match-set test_drop src -m comment --comment fwset:test_drop -j DROP",
+		"true INPUT -m set --match-set test_drop6 src -m comment --comment fwset:test_drop6 -m limit --limit 10/minute -j LOG --log-level 6 --log-prefix fwset drop: ",
+		"true INPUT -m set --match-set test_drop6 src -m comment --comment fwset:test_drop6 -j DROP",
+	}, mockConn.Rules)
+
+	assert.NoError(t, fw.Destroy())
+	assert.Empty(t, mockConn.Rules)
+	assert.Empty(t, mockConn.Elements)
+}
+
+func TestCreatePosition(t *testing.T) {
+	mockConn := NewMockConn()
+	mockConn.Rules = []string{"false INPUT -j ACCEPT"}
+	fw := NewMockFW(config.Config{
+		ChainName:     "input",
+		SetNameAccept: "test_accept",
+		SetNameDrop:   "test_drop",
+		Position:      config.PositionFirst,
+		RuleAccept:    config.Rule{NoLog: true},
+		RuleDrop:      config.Rule{NoLog: true},
+	}, mockConn)
+
+	assert.NoError(t, fw.Create(acceptSpec(fw.config)))
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	// правила вставлены перед правилом пользователя в порядке создания
+	assert.Equal(t, []string{
+		"false INPUT -m set --match-set test_accept src -m comment --comment fwset:test_accept -j ACCEPT",
+		"false INPUT -m set --match-set test_drop src -m comment --comment fwset:test_drop -j DROP",
+		"false INPUT -j ACCEPT",
+		"true INPUT -m set --match-set test_accept6 src -m comment --comment fwset:test_accept6 -j ACCEPT",
+		"true INPUT -m set --match-set test_drop6 src -m comment --comment fwset:test_drop6 -j DROP",
+	}, mockConn.Rules)
+
+	assert.NoError(t, fw.Destroy())
+	assert.Equal(t, []string{"false INPUT -j ACCEPT"}, mockConn.Rules)
+
+	fw.config.NoRules = true
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+	assert.Contains(t, mockConn.Elements, "test_drop6")
+	assert.Len(t, mockConn.Rules, 1)
+
+	assert.NoError(t, fw.Destroy())
+
+	// last добавляет правила в конец цепочки, по умолчанию они вставляются в начало
+	fw.config.NoRules = false
+	fw.config.Position = config.PositionLast
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+	assert.Equal(t, "false INPUT -m set --match-set test_drop src -m comment --comment fwset:test_drop -j DROP", mockConn.Rules[1])
+	assert.NoError(t, fw.Destroy())
+
+	fw.config.Position = "top"
+	assert.ErrorIs(t, fw.Create(dropSpec(fw.config)), config.ErrInvalidPosition)
+}
+
+func TestCreatePositionInstances(t *testing.T) {
+	mockConn := NewMockConn()
+	mockConn.Rules = []string{"false INPUT -j ACCEPT"}
+	cfg := config.Config{
+		ChainName:     "input",
+		SetNameAccept: "test_accept",
+		SetNameDrop:   "test_drop",
+		RuleAccept:    config.Rule{NoLog: true},
+		RuleDrop:      config.Rule{NoLog: true},
+		Sets:          []config.SetSpec{{Name: "test_scan", Rule: config.Rule{Verdict: config.VerdictDrop, NoLog: true}}},
+	}
+
+	// каждый сет создается отдельным запуском, правила идут в порядке приоритета сетов
+	assert.NoError(t, NewMockFW(cfg, mockConn).Create(dropSpec(cfg)))
+	assert.NoError(t, NewMockFW(cfg, mockConn).Create(cfg.Sets[0]))
+	assert.NoError(t, NewMockFW(cfg, mockConn).Create(acceptSpec(cfg)))
+
+	// правило сета drop изменено в настройках и пересоздано
+	cfg.RuleDrop.Verdict = config.VerdictReject
+	assert.NoError(t, NewMockFW(cfg, mockConn).Create(dropSpec(cfg)))
+
+	rules := slices.DeleteFunc(slices.Clone(mockConn.Rules), func(r string) bool { return strings.HasPrefix(r, "true ") })
+	assert.Equal(t, []string{
+		"false INPUT -m set --match-set test_accept src -m comment --comment fwset:test_accept -j ACCEPT",
+		"false INPUT -m set --match-set test_drop src -m comment --comment fwset:test_drop -j REJECT --reject-with icmp-port-unreachable",
+		"false INPUT -m set --match-set test_scan src -m comment --comment fwset:test_scan -j DROP",
+		"false INPUT -j ACCEPT",
+	}, rules)
+}
+
+func TestNFTOnlyOptions(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(config.Config{ChainName: "input", ChainHook: config.HookInput, ChainPolicy: config.VerdictAccept,
+		SetNameAccept: "test_accept", SetNameDrop: "test_drop"}, mockConn)
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	fw.config.ChainHook = config.HookForward
+	fw.config.Attach = true
+	err := fw.Create(dropSpec(fw.config))
+	assert.ErrorIs(t, err, config.ErrNFTOnly)
+	assert.ErrorContains(t, err, "--hook, --attach")
+}
+
+func TestRulesByTag(t *testing.T) {
+	mockConn := NewMockConn()
+	// правило без метки, созданное прежней версией
+	mockConn.Rules = []string{"false INPUT -m set --match-set test_drop src -j DROP", "false INPUT -j ACCEPT"}
+	fw := NewMockFW(config.Config{ChainName: "input", SetNameAccept: "test_accept", SetNameDrop: "test_drop", RuleDrop: config.Rule{NoLog: true}}, mockConn)
+
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+	assert.Equal(t, []string{
+		"false INPUT -m set --match-set test_drop src -m comment --comment fwset:test_drop -j DROP",
+		"false INPUT -j ACCEPT",
+		"true INPUT -m set --match-set test_drop6 src -m comment --comment fwset:test_drop6 -j DROP",
+	}, mockConn.Rules)
+
+	// после изменения правила в настройках Destroy находит правила по метке
+	fw.config.RuleDrop = config.Rule{Verdict: config.VerdictReject, RejectWith: config.RejectAdminProhibited}
+	assert.NoError(t, fw.Destroy())
+	assert.Equal(t, []string{"false INPUT -j ACCEPT"}, mockConn.Rules)
+}
+
+func TestSplitRule(t *testing.T) {
+	assert.Equal(t,
+		[]string{"-A", "INPUT", "-m", "set", "--match-set", "test_drop", "src", "-j", "LOG", "--log-prefix", `fwset "drop": `},
+		splitRule(`-A INPUT -m set --match-set test_drop src -j LOG --log-prefix "fwset \"drop\": "`))
+}
+
+func TestMatchRules(t *testing.T) {
+	tests := []struct {
+		name string
+		spec config.Rule
+		ipv6 bool
+		want string
+	}{
+		{"Reject", config.Rule{Verdict: config.VerdictReject, RejectWith: config.RejectAdminProhibited, NoLog: true},
+			false, "src -j REJECT --reject-with icmp-admin-prohibited"},
+		{"RejectIPv6", config.Rule{Verdict: config.VerdictReject, RejectWith: config.RejectPortUnreachable, NoLog: true},
+			true, "src -j REJECT --reject-with icmp6-port-unreachable"},
+		{"TCPReset", config.Rule{Verdict: config.VerdictReject, RejectWith: config.RejectTCPReset, NoLog: true},
+			false, "src -p tcp -j REJECT --reject-with tcp-reset"},
+		{"Jump", config.Rule{Verdict: config.VerdictJump, Jump: "fwset_audit", NoLog: true},
+			false, "src -j fwset_audit"},
+		{"Dst", config.Rule{Match: config.MatchDst, Verdict: config.VerdictDrop, NoLog: true},
+			false, "dst -j DROP"},
+		{"ICMPv6", config.Rule{Protocol: config.ProtocolICMP, Verdict: config.VerdictAccept, NoLog: true},
+			true, "src -p ipv6-icmp -j ACCEPT"},
+		{"Port", config.Rule{Protocol: config.ProtocolTCP, Ports: []uint16{22}, Verdict: config.VerdictReject, RejectWith: config.RejectTCPReset, NoLog: true},
+			false, "src -p tcp -m tcp --dport 22 -j REJECT --reject-with tcp-reset"},
+		{"Ports", config.Rule{Protocol: config.ProtocolUDP, Ports: []uint16{53, 123}, Verdict: config.VerdictDrop, NoLog: true},
+			false, "src -p udp -m multiport --dports 53,123 -j DROP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := matchRules("test_set", tt.ipv6, tt.spec)
+			if assert.Len(t, rules, 1) {
+				dir, rest, _ := strings.Cut(tt.want, " ")
+				assert.Equal(t, "-m set --match-set test_set "+dir+" -m comment --comment fwset:test_set "+rest, strings.Join(rules[0], " "))
+			}
+		})
+	}
 }
 
 func TestModify(t *testing.T) {
@@ -93,7 +371,7 @@ func TestModify(t *testing.T) {
 	testIP := "192.168.1.1"
 
 	// Тест добавления
-	nft.Modify(false, true, []string{testIP})
+	nft.Modify(nft.config.SetNameDrop, true, []string{testIP})
 	elements := mockConn.Elements[nft.config.SetNameDrop]
 	if len(elements) < 1 || net.IP(elements[0].IP).String() != testIP {
 		t.Error("IP not added", mockConn)
@@ -101,12 +379,289 @@ func TestModify(t *testing.T) {
 
 	// Тест удаления
 	oldLen := len(mockConn.Elements[nft.config.SetNameDrop])
-	nft.Modify(false, false, []string{testIP + "/32"})
+	nft.Modify(nft.config.SetNameDrop, false, []string{testIP + "/32"})
 	if len(mockConn.Elements[nft.config.SetNameDrop]) == oldLen {
 		t.Error("IP not removed")
 	}
 }
 
+func TestModifyIPv6(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	err := fw.Add(fw.config.SetNameDrop, []string{"2001:db8::/32", "2001:db8:1::1", "10.0.0.0/24"})
+	assert.NoError(t, err)
+
+	elements := mockConn.Elements[cfg.SetNameDrop+config.SetSuffixIPv6]
+	if assert.Len(t, elements, 2) {
+		assert.Equal(t, net.IPv6len, len(elements[0].IP))
+		assert.Equal(t, uint8(32), elements[0].CIDR)
+	}
+	assert.Len(t, mockConn.Elements[cfg.SetNameDrop], 1)
+
+	networks, err := fw.List(fw.config.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/24", "2001:db8::/32", "2001:db8:1::1"}, networks)
+
+	assert.NoError(t, fw.Remove(fw.config.SetNameDrop, []string{"2001:db8::/32"}))
+	assert.Len(t, mockConn.Elements[cfg.SetNameDrop+config.SetSuffixIPv6], 1)
+}
+
+func TestModifyRange(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	assert.NoError(t, fw.Add(fw.config.SetNameDrop, []string{"10.10.2.0-10.10.2.16", "10.10.2.17"}))
+
+	elements := mockConn.Elements[cfg.SetNameDrop]
+	assert.Len(t, elements, 3)
+
+	networks, err := fw.List(fw.config.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.10.2.0/28", "10.10.2.16", "10.10.2.17"}, networks)
+
+	rcfg := cfg
+	rcfg.ListRanges = true
+	networks, err = NewMockFW(rcfg, mockConn).List(rcfg.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.10.2.0-10.10.2.17"}, networks)
+
+	assert.NoError(t, fw.Remove(fw.config.SetNameDrop, []string{"10.10.2.0-10.10.2.16"}))
+	assert.Len(t, mockConn.Elements[cfg.SetNameDrop], 1)
+}
+
+func TestTimeout(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	assert.NoError(t, fw.Apply([]config.Change{
+		{Add: true, Networks: []string{"10.0.0.1"}, Timeout: 90 * time.Second},
+		{Add: true, Networks: []string{"10.0.0.2"}},
+	}))
+
+	elements := mockConn.Elements[cfg.SetNameDrop]
+	if assert.Len(t, elements, 2) {
+		assert.Equal(t, uint32(90), *elements[0].Timeout)
+		assert.Equal(t, uint32(0), *elements[1].Timeout)
+	}
+
+	got, err := fw.ListElements(fw.config.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Element{
+		{Network: "10.0.0.1", Expires: 90 * time.Second},
+		{Network: "10.0.0.2"},
+	}, got)
+}
+
+func TestRestoreOp(t *testing.T) {
+	entries, err := CIDRToEntries("10.0.0.1")
+	assert.NoError(t, err)
+
+	// элемент без таймаута, например, добавленный в сет прежней версии, восстанавливается постоянным
+	prev := map[string]ipset.Entry{"10.0.0.1": *entries[0]}
+	op := restoreOp(prev, entryOp{entry: entries[0]})
+	assert.True(t, op.add)
+	if assert.NotNil(t, op.entry.Timeout) {
+		assert.Equal(t, uint32(0), *op.entry.Timeout)
+	}
+
+	// элемента не было - отмена удаляет его
+	op = restoreOp(map[string]ipset.Entry{}, entryOp{add: true, entry: entries[0]})
+	assert.False(t, op.add)
+}
+
+func TestCopyEntry(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"create test_drop hash:net family inet hashsize 1024 maxelem 65536\n", "", false},
+		{"add test_drop6 2001:db8::1\n", "", false},
+		{"add test_drop 10.0.0.1\n", "add test_drop_tx 10.0.0.1 timeout 0", true},
+		{"add test_drop 10.0.0.0/24 timeout 90 comment \"scan\"\n", "add test_drop_tx 10.0.0.0/24 timeout 90 comment \"scan\"", true},
+		{"add test_drop 10.0.0.2 comment \"no timeout here\"\n", "add test_drop_tx 10.0.0.2 comment \"no timeout here\" timeout 0", true},
+	}
+
+	for _, tt := range tests {
+		got, ok := copyEntry(tt.line, "test_drop", "test_drop_tx")
+		assert.Equal(t, tt.ok, ok, tt.line)
+		assert.Equal(t, tt.want, got, tt.line)
+	}
+}
+
+func TestTimeoutRange(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	err := fw.Apply([]config.Change{{Add: true, Networks: []string{"10.0.0.1"}, Timeout: 25 * 24 * time.Hour}})
+	assert.ErrorIs(t, err, ErrTimeoutRange)
+	assert.Empty(t, mockConn.Elements[cfg.SetNameDrop])
+}
+
+func TestCreateMigrate(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+
+	// сет создан прежней версией без таймаутов и комментариев
+	assert.NoError(t, mockConn.Create(cfg.SetNameDrop, ipset.TypeHashNet, ipset.CreateOptions{Family: ipset.FamilyIPV4}))
+	mockConn.Elements[cfg.SetNameDrop] = []ipset.Entry{{IP: net.ParseIP("10.0.0.1").To4(), CIDR: 32}}
+
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	list, err := mockConn.List(cfg.SetNameDrop)
+	assert.NoError(t, err)
+	if assert.NotNil(t, list.Timeout) {
+		assert.Equal(t, uint32(MaxTimeout), *list.Timeout)
+	}
+	assert.NotZero(t, list.CadtFlags&ipset.IPSET_FLAG_WITH_COMMENT)
+	assert.Len(t, list.Entries, 1)
+	assert.NotContains(t, mockConn.Elements, cfg.SetNameDrop+TxSuffix)
+}
+
+func TestComment(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+	fw.config.ListRanges = true
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	assert.NoError(t, fw.Apply([]config.Change{
+		{Add: true, Networks: []string{"10.0.0.1"}, Comment: "abuse ticket 123"},
+		{Add: true, Networks: []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"}},
+	}))
+
+	elements := mockConn.Elements[cfg.SetNameDrop]
+	if assert.Len(t, elements, 4) {
+		assert.Equal(t, "abuse ticket 123", elements[0].Comment)
+		assert.Empty(t, elements[1].Comment)
+	}
+
+	// элемент с комментарием не объединяется со смежными
+	got, err := fw.ListElements(fw.config.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Element{
+		{Network: "10.0.0.1", Comment: "abuse ticket 123"},
+		{Network: "10.0.0.2-10.0.0.4"},
+	}, got)
+}
+
+func TestApplyAtomic(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+	assert.NoError(t, fw.Create(acceptSpec(fw.config)))
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+	assert.NoError(t, fw.Apply([]config.Change{
+		{Add: true, Networks: []string{"10.0.0.1"}, Timeout: time.Minute, Comment: "scan"},
+		{Add: true, Networks: []string{"10.0.0.2", "2001:db8::1"}},
+	}))
+
+	// перенос сети из drop в accept
+	assert.NoError(t, fw.Apply([]config.Change{
+		{Networks: []string{"10.0.0.2"}},
+		{Accept: true, Add: true, Networks: []string{"10.0.0.2"}},
+	}))
+
+	got, err := fw.ListElements(cfg.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Element{
+		{Network: "10.0.0.1", Expires: time.Minute, Comment: "scan"},
+		{Network: "2001:db8::1"},
+	}, got)
+
+	networks, err := fw.List(cfg.SetNameAccept)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2"}, networks)
+
+	// при ошибке в одном сете не меняется ни один
+	mockConn.Full = cfg.SetNameDrop + config.SetSuffixIPv6
+	err = fw.Apply([]config.Change{
+		{Add: true, Networks: []string{"10.0.0.3", "2001:db8::2"}},
+	})
+	assert.Error(t, err)
+	assert.Len(t, mockConn.Elements[cfg.SetNameDrop], 1)
+	assert.Len(t, mockConn.Elements[cfg.SetNameDrop+config.SetSuffixIPv6], 1)
+
+	// сеты с добавлениями и удалениями меняются копией
+	mixed := []config.Change{
+		{Networks: []string{"10.0.0.1", "2001:db8::1"}},
+		{Add: true, Networks: []string{"10.0.0.3", "2001:db8::2"}},
+	}
+	mockConn.Full = cfg.SetNameDrop + config.SetSuffixIPv6 + TxSuffix
+	assert.Error(t, fw.Apply(mixed))
+
+	// ошибка swap возвращает уже подмененные сеты
+	mockConn.Full = ""
+	mockConn.NoSwap = cfg.SetNameDrop + config.SetSuffixIPv6
+	assert.Error(t, fw.Apply(mixed))
+
+	got, err = fw.ListElements(cfg.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Element{
+		{Network: "10.0.0.1", Expires: time.Minute, Comment: "scan"},
+		{Network: "2001:db8::1"},
+	}, got)
+	assert.NotContains(t, mockConn.Elements, cfg.SetNameDrop+TxSuffix)
+	assert.NotContains(t, mockConn.Elements, cfg.SetNameDrop+config.SetSuffixIPv6+TxSuffix)
+
+	mockConn.NoSwap = ""
+	assert.NoError(t, fw.Apply(mixed))
+	networks, err = fw.List(cfg.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.3", "2001:db8::2"}, networks)
+
+	assert.Error(t, fw.Add(cfg.SetNameDrop, []string{"10.0.0.4", "invalid"}))
+	assert.Len(t, mockConn.Elements[cfg.SetNameDrop], 1)
+}
+
+func TestStaleTx(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+
+	// временный сет остался после аварийного завершения
+	tmp := cfg.SetNameDrop + TxSuffix
+	mockConn.Elements[tmp] = []ipset.Entry{{IP: net.ParseIP("10.0.0.9").To4(), CIDR: 32}}
+
+	assert.NoError(t, fw.Replace(config.Change{Networks: []string{"10.0.0.1"}}))
+	networks, err := fw.List(cfg.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, networks)
+
+	mockConn.Elements[tmp] = []ipset.Entry{}
+	assert.NoError(t, fw.Destroy())
+	assert.Empty(t, mockConn.Elements)
+}
+
+func TestReplace(t *testing.T) {
+	mockConn := NewMockConn()
+	fw := NewMockFW(cfg, mockConn)
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+	assert.NoError(t, fw.Add(cfg.SetNameDrop, []string{"10.0.0.1", "10.0.1.0/24", "2001:db8::1"}))
+
+	assert.NoError(t, fw.Replace(config.Change{Networks: []string{"10.0.2.0/24", "10.0.3.1"}}))
+
+	networks, err := fw.List(cfg.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.2.0/24", "10.0.3.1"}, networks)
+	assert.Len(t, mockConn.Elements, 2)
+
+	// постоянные элементы добавляются с явным timeout 0, иначе получат таймаут сета по умолчанию
+	for _, e := range mockConn.Elements[cfg.SetNameDrop] {
+		if assert.NotNil(t, e.Timeout) {
+			assert.Equal(t, uint32(0), *e.Timeout)
+		}
+	}
+
+	mockConn.Full = cfg.SetNameDrop + TxSuffix
+	assert.Error(t, fw.Replace(config.Change{Networks: []string{"10.0.4.1"}}))
+	assert.Len(t, mockConn.Elements[cfg.SetNameDrop], 2)
+	assert.Len(t, mockConn.Elements, 2)
+}
+
 // Интеграционные тесты (требуют root)
 func TestIntegration(t *testing.T) {
 	if os.Getuid() != 0 {
@@ -116,7 +671,7 @@ func TestIntegration(t *testing.T) {
 	nft, err := New(cfg)
 	assert.NoError(t, err)
 	t.Run("CreateAndList", func(t *testing.T) {
-		nft.Create(false)
+		nft.Create(dropSpec(nft.config))
 		defer cleanup(t, nft, false)
 
 		// Проверка создания
@@ -127,17 +682,17 @@ func TestIntegration(t *testing.T) {
 
 	t.Run("AddRemoveIP", func(t *testing.T) {
 		testIP := "8.8.8.8"
-		nft.Create(false)
+		nft.Create(dropSpec(nft.config))
 		defer cleanup(t, nft, false)
 
 		// Добавление
-		nft.Modify(false, true, []string{testIP + "/32"})
+		nft.Modify(nft.config.SetNameDrop, true, []string{testIP + "/32"})
 		if !ipInSet(t, nft, false, testIP) {
 			t.Error("IP не добавлен")
 		}
 
 		// Удаление
-		nft.Modify(false, false, []string{testIP + "/32"})
+		nft.Modify(nft.config.SetNameDrop, false, []string{testIP + "/32"})
 		if ipInSet(t, nft, false, testIP) {
 			t.Error("IP не удалён")
 		}
@@ -147,14 +702,14 @@ func TestIntegration(t *testing.T) {
 func setExists(t *testing.T, nft *FireWall, accept bool) bool {
 	t.Helper()
 
-	setname := nft.setName(accept)
+	setname := nft.config.SetName(accept)
 	_, err := nft.conn.List(setname)
 	return err == nil
 }
 
 func ipInSet(t *testing.T, nft *FireWall, accept bool, ip string) bool {
 	t.Helper()
-	setname := nft.setName(accept)
+	setname := nft.config.SetName(accept)
 	conn := nft.conn
 	set, err := conn.List(setname)
 	if err != nil {
@@ -173,10 +728,71 @@ func ipInSet(t *testing.T, nft *FireWall, accept bool, ip string) bool {
 }
 
 func cleanup(t *testing.T, nft *FireWall, accept bool) {
-	setname := nft.setName(accept)
-	if err := nft.conn.Destroy(setname); err != nil {
-		t.Logf("Destroy error: %v", err)
-		return
+	setname := nft.config.SetName(accept)
+	for _, fam := range families {
+		if err := nft.conn.Destroy(setname + fam.suffix); err != nil {
+			t.Logf("Destroy error: %v", err)
+			return
+		}
 	}
+}
+
+// dropSpec возвращает описание сета drop из настроек.
+func dropSpec(c config.Config) config.SetSpec {
+	spec := config.SetSpec{Name: c.SetNameDrop, Rule: c.RuleDrop}
+	if spec.Verdict == "" {
+		spec.Verdict = config.VerdictDrop
+	}
+	return spec
+}
 
+// acceptSpec возвращает описание сета accept из настроек.
+func acceptSpec(c config.Config) config.SetSpec {
+	spec := config.SetSpec{Name: c.SetNameAccept, Rule: c.RuleAccept}
+	if spec.Verdict == "" {
+		spec.Verdict = config.VerdictAccept
+	}
+	return spec
+}
+
+func TestRecorder(t *testing.T) {
+	mockConn := NewMockConn()
+	var out strings.Builder
+	fw := NewMockFW(config.Config{
+		ChainName:     "input",
+		SetNameAccept: "test_accept",
+		SetNameDrop:   "test_drop",
+		RuleDrop:      config.Rule{LogPrefix: "fwset drop: "},
+	}, mockConn)
+	fw.conn = NewRecorder(mockConn, &out)
+	fw.rules = NewRuleRecorder(mockConn, &out)
+
+	assert.NoError(t, fw.Create(dropSpec(fw.config)))
+	assert.NoError(t, fw.Apply([]config.Change{
+		{Set: "test_drop", Add: true, Networks: []string{"10.0.0.0/24"}, Comment: "abuse ticket 123"},
+		{Set: "test_drop", Networks: []string{"2001:db8::1"}},
+	}))
+	assert.NoError(t, fw.Apply([]config.Change{
+		{Set: "test_drop", Networks: []string{"10.0.0.0/24"}},
+		{Set: "test_drop", Add: true, Networks: []string{"10.0.0.0/23"}},
+	}))
+
+	assert.Equal(t, `ipset create test_drop hash:net family inet timeout 2147483 comment -exist
+iptables -I INPUT 1 -m set --match-set test_drop src -m comment --comment fwset:test_drop -j LOG --log-level 4 --log-prefix "fwset drop: "
+iptables -I INPUT 2 -m set --match-set test_drop src -m comment --comment fwset:test_drop -j DROP
+ipset create test_drop6 hash:net family inet6 timeout 2147483 comment -exist
+ip6tables -I INPUT 1 -m set --match-set test_drop6 src -m comment --comment fwset:test_drop6 -j LOG --log-level 4 --log-prefix "fwset drop: "
+ip6tables -I INPUT 2 -m set --match-set test_drop6 src -m comment --comment fwset:test_drop6 -j DROP
+ipset add test_drop 10.0.0.0/24 timeout 0 comment "abuse ticket 123" -exist
+ipset del test_drop6 2001:db8::1
+ipset create test_drop_tx hash:net family inet timeout 2147483 comment -exist
+ipset flush test_drop_tx
+ipset save test_drop | sed -n 's/^add test_drop /add test_drop_tx /p' | ipset restore -exist
+ipset del test_drop_tx 10.0.0.0/24
+ipset add test_drop_tx 10.0.0.0/23 timeout 0 -exist
+ipset swap test_drop_tx test_drop
+ipset destroy test_drop_tx
+`, out.String())
+	assert.Empty(t, mockConn.Elements)
+	assert.Empty(t, mockConn.Rules)
 }
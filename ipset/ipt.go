@@ -0,0 +1,153 @@
+package ipset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// IPT описывает операции с правилами iptables/ip6tables.
+type IPT interface {
+	Exists(ipv6 bool, chain string, rule ...string) (bool, error)
+	Append(ipv6 bool, chain string, rule ...string) error
+	Insert(ipv6 bool, chain string, pos int, rule ...string) error
+	Delete(ipv6 bool, chain string, rule ...string) error
+	ListRules(ipv6 bool, chain string) ([][]string, error)
+}
+
+// IPTables управляет правилами через утилиты iptables и ip6tables.
+type IPTables struct{}
+
+func (t IPTables) Exists(ipv6 bool, chain string, rule ...string) (bool, error) {
+	err := t.run(ipv6, append([]string{"-C", chain}, rule...))
+	if err == nil {
+		return true, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// правило не найдено
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (t IPTables) Append(ipv6 bool, chain string, rule ...string) error {
+	return t.run(ipv6, append([]string{"-A", chain}, rule...))
+}
+
+func (t IPTables) Insert(ipv6 bool, chain string, pos int, rule ...string) error {
+	return t.run(ipv6, append([]string{"-I", chain, strconv.Itoa(pos)}, rule...))
+}
+
+func (t IPTables) Delete(ipv6 bool, chain string, rule ...string) error {
+	return t.run(ipv6, append([]string{"-D", chain}, rule...))
+}
+
+// ListRules возвращает правила цепочки (iptables -S) без префикса "-A <chain>".
+func (t IPTables) ListRules(ipv6 bool, chain string) ([][]string, error) {
+	out, err := t.output(ipv6, []string{"-S", chain})
+	if err != nil {
+		return nil, err
+	}
+
+	var rv [][]string
+
+	for line := range strings.Lines(out) {
+		args := splitRule(strings.TrimSpace(line))
+		if len(args) > 2 && args[0] == "-A" {
+			rv = append(rv, args[2:])
+		}
+	}
+
+	return rv, nil
+}
+
+func (t IPTables) run(ipv6 bool, args []string) error {
+	_, err := t.output(ipv6, args)
+
+	return err
+}
+
+func (t IPTables) output(ipv6 bool, args []string) (string, error) {
+	name := iptablesName(ipv6)
+	args = append([]string{"-w"}, args...) // ждем освобождения xtables lock
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(name, args...) //nolint:gosec // имя команды не задается извне
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return string(out), nil
+}
+
+// splitRule разбирает строку правила iptables -S на аргументы.
+// Аргументы с пробелами выводятся в двойных кавычках, кавычки и \ внутри экранируются.
+func splitRule(line string) []string {
+	var (
+		rv      []string
+		arg     strings.Builder
+		inArg   bool
+		quoted  bool
+		escaped bool
+	)
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case c == ' ' && !quoted:
+			if inArg {
+				rv = append(rv, arg.String())
+				arg.Reset()
+			}
+
+			inArg = false
+		default:
+			arg.WriteRune(c)
+
+			inArg = true
+		}
+	}
+
+	if inArg {
+		rv = append(rv, arg.String())
+	}
+
+	return rv
+}
+
+// iptablesName возвращает имя утилиты для семейства адресов.
+func iptablesName(ipv6 bool) string {
+	if ipv6 {
+		return "ip6tables"
+	}
+
+	return "iptables"
+}
+
+// chainName возвращает имя цепочки iptables, встроенные цепочки пишутся заглавными.
+func chainName(name string) string {
+	switch lower := strings.ToLower(name); lower {
+	case "input", "forward", "output":
+		return strings.ToUpper(lower)
+	}
+
+	return name
+}
@@ -0,0 +1,204 @@
+package ipset
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lrh3321/ipset-go"
+)
+
+// Recorder выводит изменения в синтаксисе ipset вместо отправки в ядро.
+// Чтение сетов выполняется через исходное соединение.
+type Recorder struct {
+	conn IPS
+	w    io.Writer
+}
+
+// NewRecorder возвращает соединение для режима dry run.
+func NewRecorder(conn IPS, w io.Writer) *Recorder {
+	return &Recorder{conn: conn, w: w}
+}
+
+// familyNames - имена семейств ipset.
+var familyNames = map[uint8]string{
+	ipset.FamilyIPV4: "inet",
+	ipset.FamilyIPV6: "inet6",
+}
+
+func (r *Recorder) Create(setname, typename string, options ipset.CreateOptions) error {
+	args := []string{"create", setname, typename, "family", familyNames[options.Family]}
+	if options.Timeout != 0 {
+		args = append(args, "timeout", strconv.FormatUint(uint64(options.Timeout), 10))
+	}
+
+	if options.Comments {
+		args = append(args, "comment")
+	}
+
+	if options.Replace {
+		args = append(args, "-exist")
+	}
+
+	r.print("ipset", args)
+
+	return nil
+}
+
+func (r *Recorder) Add(setname string, entry *ipset.Entry) error {
+	args := []string{"add", setname, EntryToCIDR(*entry)}
+	if entry.Timeout != nil {
+		args = append(args, "timeout", strconv.FormatUint(uint64(*entry.Timeout), 10))
+	}
+
+	if entry.Comment != "" {
+		args = append(args, "comment", entry.Comment)
+	}
+
+	if entry.Replace {
+		args = append(args, "-exist")
+	}
+
+	r.print("ipset", args)
+
+	return nil
+}
+
+func (r *Recorder) Del(setname string, entry *ipset.Entry) error {
+	r.print("ipset", []string{"del", setname, EntryToCIDR(*entry)})
+
+	return nil
+}
+
+func (r *Recorder) List(setname string) (*ipset.Sets, error) {
+	return r.conn.List(setname)
+}
+
+func (r *Recorder) Destroy(setname string) error {
+	r.print("ipset", []string{"destroy", setname})
+
+	return nil
+}
+
+func (r *Recorder) Flush(setname string) error {
+	r.print("ipset", []string{"flush", setname})
+
+	return nil
+}
+
+func (r *Recorder) Swap(from, to string) error {
+	r.print("ipset", []string{"swap", from, to})
+
+	return nil
+}
+
+// Copy выводит одну команду копирования вместо команды на каждый элемент сета.
+func (r *Recorder) Copy(from, to string) error {
+	fmt.Fprintf(r.w, "ipset save %s | sed -n 's/^add %s /add %s /p' | ipset restore -exist\n", from, from, to)
+
+	return nil
+}
+
+func (r *Recorder) print(name string, args []string) {
+	printCommand(r.w, name, args)
+}
+
+// RuleRecorder выводит команды iptables/ip6tables вместо их выполнения.
+// Наличие правила проверяется через исходный IPT. Правила цепочки читаются через него один раз,
+// затем к копии применяются выведенные команды, чтобы позиции следующих правил их учитывали.
+type RuleRecorder struct {
+	rules  IPT
+	w      io.Writer
+	chains map[string][][]string // правила цепочек по iptablesName и имени цепочки
+}
+
+// NewRuleRecorder возвращает IPT для режима dry run.
+func NewRuleRecorder(rules IPT, w io.Writer) *RuleRecorder {
+	return &RuleRecorder{rules: rules, w: w, chains: make(map[string][][]string)}
+}
+
+func (r *RuleRecorder) Exists(ipv6 bool, chain string, rule ...string) (bool, error) {
+	return r.rules.Exists(ipv6, chain, rule...)
+}
+
+func (r *RuleRecorder) ListRules(ipv6 bool, chain string) ([][]string, error) {
+	rules, err := r.chain(ipv6, chain)
+
+	return slices.Clone(rules), err
+}
+
+func (r *RuleRecorder) Append(ipv6 bool, chain string, rule ...string) error {
+	rules, err := r.chain(ipv6, chain)
+	if err != nil {
+		return err
+	}
+
+	printCommand(r.w, iptablesName(ipv6), append([]string{"-A", chain}, rule...))
+	r.chains[chainKey(ipv6, chain)] = append(rules, rule)
+
+	return nil
+}
+
+func (r *RuleRecorder) Insert(ipv6 bool, chain string, pos int, rule ...string) error {
+	rules, err := r.chain(ipv6, chain)
+	if err != nil {
+		return err
+	}
+
+	printCommand(r.w, iptablesName(ipv6), append([]string{"-I", chain, strconv.Itoa(pos)}, rule...))
+	r.chains[chainKey(ipv6, chain)] = slices.Insert(rules, min(max(pos, 1), len(rules)+1)-1, rule)
+
+	return nil
+}
+
+func (r *RuleRecorder) Delete(ipv6 bool, chain string, rule ...string) error {
+	rules, err := r.chain(ipv6, chain)
+	if err != nil {
+		return err
+	}
+
+	printCommand(r.w, iptablesName(ipv6), append([]string{"-D", chain}, rule...))
+
+	if i := slices.IndexFunc(rules, func(have []string) bool { return slices.Equal(have, rule) }); i >= 0 {
+		r.chains[chainKey(ipv6, chain)] = slices.Delete(rules, i, i+1)
+	}
+
+	return nil
+}
+
+// chain возвращает правила цепочки с учетом выведенных команд.
+func (r *RuleRecorder) chain(ipv6 bool, chain string) ([][]string, error) {
+	key := chainKey(ipv6, chain)
+	if rules, ok := r.chains[key]; ok {
+		return rules, nil
+	}
+
+	rules, err := r.rules.ListRules(ipv6, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	r.chains[key] = rules
+
+	return rules, nil
+}
+
+// chainKey возвращает ключ цепочки в RuleRecorder.
+func chainKey(ipv6 bool, chain string) string {
+	return iptablesName(ipv6) + " " + chain
+}
+
+// printCommand выводит команду, аргументы с пробелами и кавычками экранируются.
+func printCommand(w io.Writer, name string, args []string) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\$") {
+			quoted[i] = strconv.Quote(arg)
+		}
+	}
+
+	fmt.Fprintln(w, name, strings.Join(quoted, " "))
+}
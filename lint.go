@@ -0,0 +1,247 @@
+package fwset
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/utils"
+)
+
+const (
+	FindingConflict = "conflict" // элементы сетов accept и drop пересекаются
+	FindingShadowed = "shadowed" // элемент покрыт элементом сета с большим приоритетом и тем же вердиктом
+	FindingSubsumed = "subsumed" // элемент покрыт другим элементом того же сета
+)
+
+// ErrConflict возвращается, если элементы сетов accept и drop пересекаются.
+var ErrConflict = errors.New("accept and drop sets overlap")
+
+// Finding описывает проблему в содержимом сетов.
+type Finding struct {
+	Kind    string `json:"kind"    yaml:"kind"`
+	Set     string `json:"set"     yaml:"set"`
+	Network string `json:"network" yaml:"network"`
+	BySet   string `json:"by_set"  yaml:"by_set"`
+	By      string `json:"by"      yaml:"by"`
+}
+
+// String возвращает описание проблемы.
+func (f Finding) String() string {
+	if f.Kind == FindingConflict {
+		return fmt.Sprintf("%s: %s %s overlaps %s %s", f.Kind, f.Set, f.Network, f.BySet, f.By)
+	}
+
+	return fmt.Sprintf("%s: %s %s covered by %s %s", f.Kind, f.Set, f.Network, f.BySet, f.By)
+}
+
+// Lint ищет пересечения элементов сетов accept и drop, элементы, покрытые сетом
+// с большим приоритетом, и элементы, покрытые другим элементом того же сета.
+// Сеты с разным направлением проверки (src, dst) не сравниваются.
+func (fw *Firewall) Lint() ([]Finding, error) {
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	sets, err := fw.Sets()
+	if err != nil {
+		return nil, err
+	}
+
+	return lint(specs, sets)
+}
+
+// lintItem - элемент сета с разобранным диапазоном.
+type lintItem struct {
+	set     int // индекс сета в порядке приоритета
+	network string
+	r       utils.IPRange
+}
+
+// lint сравнивает элементы сетов, sets идут в порядке specs.
+// Элементы сортируются по началу диапазона, каждый сравнивается только с пересекающими его предыдущими.
+func lint(specs []config.SetSpec, sets []Set) ([]Finding, error) {
+	var items []lintItem
+
+	for i, set := range sets {
+		for _, elem := range set.Elements {
+			r, err := utils.ParseRange(elem.Network)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, lintItem{set: i, network: elem.Network, r: r})
+		}
+	}
+
+	slices.SortStableFunc(items, func(a, b lintItem) int {
+		if c := a.r.Start.Compare(b.r.Start); c != 0 {
+			return c
+		}
+
+		return b.r.End.Compare(a.r.End) // больший диапазон раньше
+	})
+
+	var (
+		rv     []Finding
+		active []lintItem
+	)
+
+	for _, item := range items {
+		active = slices.DeleteFunc(active, func(a lintItem) bool { return !a.r.Overlaps(item.r) })
+
+		for _, a := range active {
+			if f, ok := compare(specs, sets, a, item); ok {
+				rv = append(rv, f)
+			}
+		}
+
+		active = append(active, item)
+	}
+
+	return rv, nil
+}
+
+// compare возвращает проблему для пересекающихся элементов a и b, a.r.Start <= b.r.Start.
+func compare(specs []config.SetSpec, sets []Set, a, b lintItem) (Finding, bool) {
+	if a.set == b.set {
+		if !a.r.Contains(b.r) {
+			return Finding{}, false
+		}
+
+		return Finding{Kind: FindingSubsumed, Set: sets[b.set].Name, Network: b.network, BySet: sets[a.set].Name, By: a.network}, true
+	}
+
+	if specs[a.set].Match != specs[b.set].Match {
+		return Finding{}, false
+	}
+
+	// first - элемент сета с большим приоритетом, его правило проверяется раньше
+	first, next := a, b
+	if b.set < a.set {
+		first, next = b, a
+	}
+
+	f := Finding{Set: sets[next.set].Name, Network: next.network, BySet: sets[first.set].Name, By: first.network}
+
+	switch {
+	case (sets[a.set].Verdict == VerdictAccept) != (sets[b.set].Verdict == VerdictAccept):
+		f.Kind = FindingConflict
+	case first.r.Contains(next.r):
+		f.Kind = FindingShadowed
+	default:
+		return Finding{}, false
+	}
+
+	return f, true
+}
+
+// addConflicts возвращает пересечения добавляемых сетей с элементами сетов с другим вердиктом.
+func (fw *Firewall) addConflicts(change config.Change) ([]Finding, error) {
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	sets, err := fw.Sets()
+	if err != nil {
+		return nil, err
+	}
+
+	return conflicts(specs, sets, map[string][]string{fw.config.ChangeSet(change): change.Networks})
+}
+
+// conflicts добавляет в сеты сети added (по именам сетов) и возвращает их пересечения
+// с элементами сетов с другим вердиктом. sets идут в порядке specs.
+func conflicts(specs []config.SetSpec, sets []Set, added map[string][]string) ([]Finding, error) {
+	isAdded := make(map[[2]string]bool)
+
+	for i := range sets {
+		for _, network := range added[sets[i].Name] {
+			isAdded[[2]string{sets[i].Name, network}] = true
+			sets[i].Elements = append(sets[i].Elements, Element{Network: network})
+		}
+	}
+
+	findings, err := lint(specs, sets)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []Finding
+
+	for _, f := range findings {
+		if f.Kind == FindingConflict && (isAdded[[2]string{f.Set, f.Network}] || isAdded[[2]string{f.BySet, f.By}]) {
+			rv = append(rv, f)
+		}
+	}
+
+	return rv, nil
+}
+
+// checkConflicts проверяет добавляемые сети перед AddChange и Replace.
+func (fw *Firewall) checkConflicts(change config.Change) error {
+	conflicts, err := fw.addConflicts(change)
+	if err != nil {
+		return err
+	}
+
+	return fw.reportConflicts(conflicts)
+}
+
+// reportConflicts обрабатывает пересечения добавляемых сетей.
+// Если задан Strict, пересечение с сетом с другим вердиктом отменяет добавление, иначе только логируется.
+func (fw *Firewall) reportConflicts(conflicts []Finding) error {
+	if len(conflicts) > 0 && fw.config.Strict {
+		return fmt.Errorf("%w: %s", ErrConflict, conflicts[0])
+	}
+
+	for _, f := range conflicts {
+		slog.Warn("Conflict", "set", f.Set, "network", f.Network, "by_set", f.BySet, "by", f.By)
+	}
+
+	return nil
+}
+
+// WriteFindings выводит проблемы в заданном формате.
+func WriteFindings(w io.Writer, format string, findings []Finding) error {
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(findings)
+	case OutputYAML:
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+
+		return enc.Encode(findings)
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"kind", "set", "network", "by_set", "by"})
+
+		for _, f := range findings {
+			_ = cw.Write([]string{f.Kind, f.Set, f.Network, f.BySet, f.By})
+		}
+
+		cw.Flush()
+
+		return cw.Error()
+	case OutputPlain:
+		for _, f := range findings {
+			fmt.Fprintln(w, f)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownOutput, format)
+	}
+}
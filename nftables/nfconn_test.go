@@ -1,20 +1,30 @@
 package nftables
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/google/nftables/userdata"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 
 	"github.com/LeKovr/fwset/config"
 )
 
 var cfg = config.Config{
-	TableName:   "test_table",
-	ChainName:   "input",
-	SetNameDrop: "test_set",
+	TableName:     "test_table",
+	ChainName:     "input",
+	SetNameAccept: "test_accept",
+	SetNameDrop:   "test_set",
 }
 
 // MockNFTConn для тестирования без реального взаимодействия с nftables
@@ -24,6 +34,9 @@ type MockNFTConn struct {
 	Rules    []*nftables.Rule
 	Sets     []*nftables.Set
 	Elements map[string][]nftables.SetElement
+	Messages int
+	Handle   uint64 // последний назначенный handle правила
+	FlushErr error  // ошибка, которую возвращает Flush
 }
 
 func NewMockNFTConn() *MockNFTConn {
@@ -33,16 +46,24 @@ func NewMockNFTConn() *MockNFTConn {
 	}
 }
 
+// tableKey возвращает ключ таблицы в Tables: имя для inet, для других семейств - с семейством.
+func tableKey(t *nftables.Table) string {
+	if t.Family == nftables.TableFamilyINet {
+		return t.Name
+	}
+	return fmt.Sprintf("%d %s", t.Family, t.Name)
+}
+
 func (m *MockNFTConn) AddTable(t *nftables.Table) *nftables.Table {
-	rv, ok := m.Tables[t.Name]
+	rv, ok := m.Tables[tableKey(t)]
 	if ok {
 		return rv
 	}
-	m.Tables[t.Name] = t
+	m.Tables[tableKey(t)] = t
 	return t
 }
 func (m *MockNFTConn) DelTable(t *nftables.Table) {
-	delete(m.Tables, t.Name)
+	delete(m.Tables, tableKey(t))
 }
 
 func (m *MockNFTConn) AddChain(c *nftables.Chain) *nftables.Chain {
@@ -51,11 +72,37 @@ func (m *MockNFTConn) AddChain(c *nftables.Chain) *nftables.Chain {
 }
 
 func (m *MockNFTConn) AddRule(r *nftables.Rule) *nftables.Rule {
+	m.Handle++
+	r.Handle = m.Handle
 	m.Rules = append(m.Rules, r)
 	return r
 }
 
+func (m *MockNFTConn) InsertRule(r *nftables.Rule) *nftables.Rule {
+	i := slices.IndexFunc(m.Rules, func(rule *nftables.Rule) bool { return rule.Handle == r.Position })
+	if i < 0 {
+		i = 0
+	}
+	m.Handle++
+	r.Handle = m.Handle
+	m.Rules = slices.Insert(m.Rules, i, r)
+	return r
+}
+
+func (m *MockNFTConn) DelRule(r *nftables.Rule) error {
+	m.Rules = slices.DeleteFunc(m.Rules, func(rule *nftables.Rule) bool { return rule.Handle == r.Handle })
+	return nil
+}
+
+func (m *MockNFTConn) DelSet(s *nftables.Set) {
+	m.Sets = slices.DeleteFunc(m.Sets, func(set *nftables.Set) bool { return set.Name == s.Name })
+	delete(m.Elements, s.Name)
+}
+
 func (m *MockNFTConn) AddSet(s *nftables.Set, elements []nftables.SetElement) error {
+	if s.Anonymous && s.Name == "" {
+		s.Name = fmt.Sprintf("__set%d", len(m.Sets))
+	}
 	m.Sets = append(m.Sets, s)
 	m.Elements[s.Name] = elements
 	return nil
@@ -70,12 +117,39 @@ func (m *MockNFTConn) GetSetByName(t *nftables.Table, name string) (*nftables.Se
 	return nil, os.ErrNotExist
 }
 
+func (m *MockNFTConn) GetSets(t *nftables.Table) ([]*nftables.Set, error) {
+	if _, ok := m.Tables[tableKey(t)]; !ok {
+		return nil, os.ErrNotExist
+	}
+	return m.Sets, nil
+}
+
+func (m *MockNFTConn) GetRules(t *nftables.Table, c *nftables.Chain) ([]*nftables.Rule, error) {
+	if _, ok := m.Tables[tableKey(t)]; !ok {
+		return nil, os.ErrNotExist
+	}
+	var rules []*nftables.Rule
+	for _, r := range m.Rules {
+		if r.Chain.Name == c.Name {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
 func (m *MockNFTConn) SetAddElements(s *nftables.Set, elements []nftables.SetElement) error {
+	m.Messages++
 	m.Elements[s.Name] = append(m.Elements[s.Name], elements...)
 	return nil
 }
 
+func (m *MockNFTConn) FlushSet(s *nftables.Set) {
+	m.Messages++
+	m.Elements[s.Name] = nil
+}
+
 func (m *MockNFTConn) SetDeleteElements(s *nftables.Set, elements []nftables.SetElement) error {
+	m.Messages++
 	for _, e := range elements {
 		for i, existing := range m.Elements[s.Name] {
 			if string(existing.Key) == string(e.Key) {
@@ -100,14 +174,14 @@ func NewMockNFT(cfg config.Config, mockConn *MockNFTConn) *RealNFT {
 		conn: mockConn,
 	}
 }
-func (m *MockNFTConn) Flush() error { return nil }
+func (m *MockNFTConn) Flush() error { return m.FlushErr }
 
 // Тесты с использованием моков
 func TestCreateBlocklist(t *testing.T) {
 	mockConn := NewMockNFTConn()
 
 	nft := NewMockNFT(cfg, mockConn)
-	nft.Create(false)
+	nft.Create(dropSpec(nft.config))
 
 	if len(mockConn.Tables) != 1 || mockConn.Tables[nft.config.TableName].Name != nft.config.TableName {
 		t.Error("Table not created")
@@ -115,9 +189,380 @@ func TestCreateBlocklist(t *testing.T) {
 	if len(mockConn.Chains) != 1 || mockConn.Chains[0].Name != nft.config.ChainName {
 		t.Error("Chain not created")
 	}
-	if len(mockConn.Sets) != 1 || mockConn.Sets[0].Name != nft.config.SetNameDrop {
-		t.Error("Set not created")
+	if len(mockConn.Sets) != 2 || mockConn.Sets[0].Name != nft.config.SetNameDrop ||
+		mockConn.Sets[1].Name != nft.config.SetNameDrop+config.SetSuffixIPv6 {
+		t.Error("Sets not created")
+	}
+	if mockConn.Sets[1].KeyType != nftables.TypeIP6Addr {
+		t.Error("IPv6 set has wrong key type")
+	}
+	if len(mockConn.Rules) != 2 {
+		t.Error("Rules not created")
+	}
+	if len(mockConn.Elements[nft.config.SetNameDrop+config.SetSuffixIPv6][0].Key) != net.IPv6len {
+		t.Error("IPv6 set has wrong dummy element")
+	}
+}
+
+func TestCreateTwice(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+
+	assert.Len(t, mockConn.Sets, 2)
+	if assert.Len(t, mockConn.Rules, 2) {
+		tag, ok := userdata.GetString(mockConn.Rules[0].UserData, userdata.TypeComment)
+		assert.True(t, ok)
+		assert.Equal(t, RuleTagPrefix+cfg.SetNameDrop, tag)
+	}
+
+	// сет без таймаутов, созданный прежней версией
+	mockConn.Sets[1].HasTimeout = false
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), ErrNoTimeout)
+	mockConn.Sets[1].HasTimeout = true
+
+	// правило без комментария, созданное прежней версией
+	mockConn.Rules = mockConn.Rules[1:]
+	mockConn.Rules[0].UserData = nil
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Len(t, mockConn.Rules, 2)
+}
+
+func TestCreateReport(t *testing.T) {
+	var buf strings.Builder
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	mockConn := NewMockNFTConn()
+	mockConn.FlushErr = errors.New("operation not permitted")
+	nft := NewMockNFT(cfg, mockConn)
+
+	// пока изменения не применены, о создании не сообщается
+	assert.Error(t, nft.Create(dropSpec(nft.config)))
+	assert.NotContains(t, buf.String(), "created")
+
+	nft = NewMockNFT(cfg, NewMockNFTConn())
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Contains(t, buf.String(), `msg="Set created" name=test_set`)
+}
+
+func TestLegacyTable(t *testing.T) {
+	var buf strings.Builder
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.NotContains(t, buf.String(), "previous version")
+
+	// таблица ip прежней версии остается после обновления
+	mockConn.AddTable(nft.legacyTable())
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Contains(t, buf.String(), `table="ip test_table"`)
+
+	assert.NoError(t, nft.Destroy())
+	assert.Empty(t, mockConn.Tables)
+}
+
+func TestRuleSpecs(t *testing.T) {
+	set := &nftables.Set{Name: "test_set"}
+
+	rules := ruleSpecs(familyIPv4, set, config.Rule{Verdict: config.VerdictDrop})
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, "fwset:test_set", rules[0].tag)
+		exprs := rules[0].exprs
+		assert.IsType(t, &expr.Counter{}, exprs[len(exprs)-3])
+		assert.Equal(t, &expr.Log{Level: expr.LogLevelWarning, Key: 1 << unix.NFTA_LOG_LEVEL}, exprs[len(exprs)-2])
+		assert.Equal(t, &expr.Verdict{Kind: expr.VerdictDrop}, exprs[len(exprs)-1])
+	}
+
+	// логирование с ограничением частоты - отдельным правилом
+	rules = ruleSpecs(familyIPv4, set, config.Rule{
+		Verdict:    config.VerdictReject,
+		RejectWith: config.RejectHostUnreachable,
+		NoCounter:  true,
+		LogPrefix:  "fwset: ",
+		LogLevel:   "info",
+		LogRate:    "10/minute",
+	})
+	if assert.Len(t, rules, 2) {
+		assert.Equal(t, "fwset:test_set:log", rules[0].tag)
+		assert.Equal(t, []expr.Any{
+			&expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeMinute},
+			&expr.Log{Level: expr.LogLevelInfo, Key: 1<<unix.NFTA_LOG_LEVEL | 1<<unix.NFTA_LOG_PREFIX, Data: []byte("fwset: ")},
+		}, rules[0].exprs[4:])
+		assert.Equal(t, []expr.Any{
+			&expr.Reject{Type: unix.NFT_REJECT_ICMPX_UNREACH, Code: unix.NFT_REJECT_ICMPX_HOST_UNREACH},
+		}, rules[1].exprs[4:])
+	}
+
+	rules = ruleSpecs(familyIPv6, set, config.Rule{Verdict: config.VerdictReject, RejectWith: config.RejectTCPReset, NoLog: true})
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+			&expr.Counter{},
+			&expr.Reject{Type: unix.NFT_REJECT_TCP_RST},
+		}, rules[0].exprs[4:])
+	}
+
+	// адрес назначения и протокол
+	rules = ruleSpecs(familyIPv6, set, config.Rule{Match: config.MatchDst, Protocol: config.ProtocolICMP, Verdict: config.VerdictDrop, NoLog: true})
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 24, Len: net.IPv6len}, rules[0].exprs[2])
+		assert.Equal(t, []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_ICMPV6}},
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictDrop},
+		}, rules[0].exprs[4:])
+		assert.Nil(t, rules[0].ports)
+	}
+
+	rules = ruleSpecs(familyIPv4, set, config.Rule{Protocol: config.ProtocolTCP, Ports: []uint16{22}, Verdict: config.VerdictDrop, NoLog: true})
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0, 22}},
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictDrop},
+		}, rules[0].exprs[4:])
+	}
+}
+
+func TestCreatePorts(t *testing.T) {
+	pcfg := cfg
+	pcfg.RuleDrop = config.Rule{Protocol: config.ProtocolTCP, Ports: []uint16{22, 2222}, LogRate: "1/second"}
+
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(pcfg, mockConn)
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+
+	// по анонимному сету портов на каждое правило: log и drop для IPv4 и IPv6
+	var anon []*nftables.Set
+	for _, set := range mockConn.Sets {
+		if set.Anonymous {
+			anon = append(anon, set)
+		}
+	}
+
+	if assert.Len(t, anon, 4) {
+		assert.Equal(t, nftables.TypeInetService, anon[0].KeyType)
+		assert.Equal(t, []nftables.SetElement{{Key: []byte{0, 22}}, {Key: []byte{0x08, 0xae}}}, mockConn.Elements[anon[0].Name])
+	}
+
+	if assert.Len(t, mockConn.Rules, 4) {
+		exprs := mockConn.Rules[1].Exprs
+		assert.Same(t, anon[1].Table, mockConn.Rules[1].Table)
+		assert.IsType(t, &expr.Lookup{}, exprs[7])
+	}
+
+	// повторное создание не добавляет сеты портов
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Len(t, mockConn.Sets, 6)
+
+	nft.config.RuleDrop = config.Rule{Ports: []uint16{22}}
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), config.ErrPortsNoProtocol)
+
+	nft.config.RuleDrop = config.Rule{Protocol: config.ProtocolUDP, Verdict: config.VerdictReject, RejectWith: config.RejectTCPReset}
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), config.ErrResetNotTCP)
+}
+
+func TestCreateReplace(t *testing.T) {
+	// ruleNames возвращает комментарии и вердикты правил цепочки по порядку
+	ruleNames := func(rules []*nftables.Rule) []string {
+		var rv []string
+		for _, rule := range rules {
+			tag, _ := userdata.GetString(rule.UserData, userdata.TypeComment)
+			switch v := rule.Exprs[len(rule.Exprs)-1].(type) {
+			case *expr.Verdict:
+				tag += fmt.Sprintf(" %d", v.Kind)
+			case *expr.Reject:
+				tag += " reject"
+			}
+			rv = append(rv, tag)
+		}
+		return rv
+	}
+
+	rcfg := cfg
+	rcfg.RuleDrop = config.Rule{Protocol: config.ProtocolTCP, Ports: []uint16{22, 2222}, LogRate: "1/second"}
+
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(rcfg, mockConn)
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.NoError(t, nft.Create(acceptSpec(nft.config)))
+
+	// новые правила accept вставляются перед правилами сета с меньшим приоритетом
+	assert.Equal(t, []string{
+		"fwset:test_accept 1", "fwset:test_accept6 1",
+		"fwset:test_set:log", "fwset:test_set 0",
+		"fwset:test_set6:log", "fwset:test_set6 0",
+	}, ruleNames(mockConn.Rules))
+
+	// правила accept стоят после drop (созданы прежней версией), они переносятся
+	mockConn.Rules = append(mockConn.Rules[2:], mockConn.Rules[:2]...)
+	assert.NoError(t, nft.Create(acceptSpec(nft.config)))
+	assert.Equal(t, []string{
+		"fwset:test_accept 1", "fwset:test_accept6 1",
+		"fwset:test_set:log", "fwset:test_set 0",
+		"fwset:test_set6:log", "fwset:test_set6 0",
+	}, ruleNames(mockConn.Rules))
+
+	// порты изменились: правила заменяются на месте
+	handles := len(mockConn.Rules)
+	nft.config.RuleDrop.Ports = []uint16{22}
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Len(t, mockConn.Rules, handles)
+	assert.Equal(t, []byte{0, 22}, mockConn.Rules[3].Exprs[7].(*expr.Cmp).Data)
+
+	// без частоты логирования правило log удаляется, вердикт меняется
+	nft.config.RuleDrop = config.Rule{Verdict: config.VerdictReject, RejectWith: config.RejectAdminProhibited}
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Equal(t, []string{
+		"fwset:test_accept 1", "fwset:test_accept6 1",
+		"fwset:test_set reject", "fwset:test_set6 reject",
+	}, ruleNames(mockConn.Rules))
+
+	// без изменений правила остаются
+	last := mockConn.Handle
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.NoError(t, nft.Create(acceptSpec(nft.config)))
+	assert.Equal(t, last, mockConn.Handle)
+}
+
+func TestCreateHook(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+
+	// по умолчанию - input, filter, accept
+	chain := mockConn.Chains[0]
+	assert.Equal(t, nftables.ChainHookInput, chain.Hooknum)
+	assert.Equal(t, *nftables.ChainPriorityFilter, *chain.Priority)
+	assert.Equal(t, nftables.ChainPolicyAccept, *chain.Policy)
+
+	hcfg := cfg
+	hcfg.ChainHook = config.HookForward
+	hcfg.ChainPriority = -10
+	hcfg.ChainPolicy = config.VerdictDrop
+
+	mockConn = NewMockNFTConn()
+	nft = NewMockNFT(hcfg, mockConn)
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+
+	chain = mockConn.Chains[0]
+	assert.Equal(t, nftables.ChainHookForward, chain.Hooknum)
+	assert.Equal(t, nftables.ChainPriority(-10), *chain.Priority)
+	assert.Equal(t, nftables.ChainPolicyDrop, *chain.Policy)
+	assert.Empty(t, chain.Device)
+
+	nft.config.ChainHook = config.HookIngress
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), config.ErrNoDevice)
+
+	nft.config.ChainDevice = "eth0"
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	chain = mockConn.Chains[len(mockConn.Chains)-1]
+	assert.Equal(t, nftables.ChainHookRef(5), chain.Hooknum, "NF_INET_INGRESS, not prerouting")
+	assert.Equal(t, "eth0", chain.Device)
+
+	// ядро не принимает reject в ingress
+	nft.config.RuleDrop.Verdict = config.VerdictReject
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), config.ErrRejectIngress)
+
+	nft.config.RuleDrop.Verdict = ""
+	nft.config.ChainHook = config.HookOutput
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), config.ErrDeviceHook)
+
+	nft.config.ChainHook = "egress"
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), config.ErrUnknownHook)
+}
+
+func TestAttach(t *testing.T) {
+	acfg := cfg
+	acfg.TableName = "filter"
+	acfg.SetNameAccept = "test_accept"
+	acfg.Attach = true
+	acfg.Position = config.PositionFirst
+
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(acfg, mockConn)
+	assert.Error(t, nft.Create(dropSpec(nft.config)), "no table")
+
+	// таблица и цепочка, созданные пользователем
+	table := mockConn.AddTable(&nftables.Table{Family: nftables.TableFamilyINet, Name: "filter"})
+	chain := mockConn.AddChain(&nftables.Chain{Name: "input", Table: table})
+	mockConn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: []expr.Any{&expr.Verdict{Kind: expr.VerdictAccept}}})
+	mockConn.AddSet(&nftables.Set{Name: "user_set", Table: table}, nil)
+
+	assert.NoError(t, nft.Create(acceptSpec(nft.config)))
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Len(t, mockConn.Chains, 1, "chain not created")
+
+	tags := make([]string, len(mockConn.Rules))
+	for i, rule := range mockConn.Rules {
+		tags[i], _ = userdata.GetString(rule.UserData, userdata.TypeComment)
+	}
+
+	// правила fwset вставлены перед правилом пользователя в порядке создания
+	assert.Equal(t, []string{
+		"fwset:test_accept", "fwset:test_accept6", "fwset:test_set", "fwset:test_set6", "",
+	}, tags)
+
+	assert.NoError(t, nft.Destroy())
+	assert.Contains(t, mockConn.Tables, "filter")
+	if assert.Len(t, mockConn.Rules, 1) {
+		assert.Nil(t, mockConn.Rules[0].UserData)
+	}
+	if assert.Len(t, mockConn.Sets, 1) {
+		assert.Equal(t, "user_set", mockConn.Sets[0].Name)
+	}
+
+	// сеты без правил
+	nft.config.NoRules = true
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Len(t, mockConn.Sets, 3)
+	assert.Len(t, mockConn.Rules, 1)
+
+	nft.config.NoRules = false
+	nft.config.Position = "1"
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Len(t, mockConn.Rules, 3)
+
+	nft.config.Position = "100"
+	assert.ErrorIs(t, nft.Create(acceptSpec(nft.config)), ErrNoRule)
+
+	nft.config.Position = "top"
+	assert.ErrorIs(t, nft.Create(acceptSpec(nft.config)), config.ErrInvalidPosition)
+}
+
+func TestCreateJump(t *testing.T) {
+	jcfg := cfg
+	jcfg.RuleDrop = config.Rule{Verdict: config.VerdictJump}
+
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(jcfg, mockConn)
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), config.ErrNoJumpChain)
+
+	nft.config.RuleDrop.Jump = "fwset_audit"
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+
+	if assert.Len(t, mockConn.Chains, 2) {
+		assert.Equal(t, "fwset_audit", mockConn.Chains[1].Name)
+		assert.Nil(t, mockConn.Chains[1].Hooknum, "regular chain")
 	}
+
+	exprs := mockConn.Rules[0].Exprs
+	assert.Equal(t, &expr.Verdict{Kind: expr.VerdictJump, Chain: "fwset_audit"}, exprs[len(exprs)-1])
+
+	nft.config.RuleDrop = config.Rule{LogRate: "often"}
+	assert.ErrorIs(t, nft.Create(dropSpec(nft.config)), config.ErrInvalidRate)
 }
 
 func TestModifyIP(t *testing.T) {
@@ -130,7 +575,7 @@ func TestModifyIP(t *testing.T) {
 	testIP := "192.168.1.1"
 
 	// Тест добавления
-	nft.Modify(false, true, []string{testIP})
+	nft.Modify(nft.config.SetNameDrop, true, []string{testIP})
 	elements := mockConn.Elements[nft.config.SetNameDrop]
 	if len(elements) < 1 || net.IP(elements[0].Key).String() != testIP {
 		t.Error("IP not added", mockConn)
@@ -138,12 +583,168 @@ func TestModifyIP(t *testing.T) {
 
 	// Тест удаления
 	oldLen := len(mockConn.Elements[nft.config.SetNameDrop])
-	nft.Modify(false, false, []string{testIP + "/32"})
+	nft.Modify(nft.config.SetNameDrop, false, []string{testIP + "/32"})
 	if len(mockConn.Elements[nft.config.SetNameDrop]) == oldLen {
 		t.Error("IP not removed")
 	}
 }
 
+func TestModifyIPv6(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+
+	mockConn.AddSet(&nftables.Set{Name: nft.config.SetNameDrop}, nil)
+	mockConn.AddSet(&nftables.Set{Name: nft.config.SetNameDrop + config.SetSuffixIPv6}, nil)
+
+	err := nft.Modify(nft.config.SetNameDrop, true, []string{"2001:db8::/32", "10.0.0.0/24"})
+	assert.NoError(t, err)
+
+	elements := mockConn.Elements[nft.config.SetNameDrop+config.SetSuffixIPv6]
+	if assert.Len(t, elements, 2) {
+		assert.Equal(t, []byte(net.ParseIP("2001:db8::")), elements[0].Key)
+		assert.Equal(t, []byte(net.ParseIP("2001:db9::")), elements[1].Key)
+		assert.True(t, elements[1].IntervalEnd)
+	}
+	assert.Len(t, mockConn.Elements[nft.config.SetNameDrop], 2)
+
+	err = nft.Modify(nft.config.SetNameDrop, true, []string{"10.0.0.1-2001:db8::1"})
+	assert.Error(t, err)
+}
+
+func TestApply(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	acfg := cfg
+	acfg.SetNameAccept = "test_accept"
+	nft := NewMockNFT(acfg, mockConn)
+
+	assert.NoError(t, nft.Create(acceptSpec(nft.config)))
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+
+	err := nft.Apply([]config.Change{
+		{Accept: false, Add: true, Networks: []string{"10.0.0.0/24"}},
+		{Accept: true, Add: true, Networks: []string{"2001:db8::1"}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, mockConn.Elements[acfg.SetNameDrop], 3)
+	assert.Len(t, mockConn.Elements[acfg.SetNameAccept+config.SetSuffixIPv6], 3)
+
+	// ошибка в любом изменении не оставляет сообщений в очереди
+	messages := mockConn.Messages
+	err = nft.Apply([]config.Change{
+		{Accept: true, Networks: []string{"2001:db8::1"}},
+		{Accept: false, Add: true, Networks: []string{"2001:db8::1", "invalid"}},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, messages, mockConn.Messages)
+	assert.Len(t, mockConn.Elements[acfg.SetNameAccept+config.SetSuffixIPv6], 3)
+}
+
+func TestReplace(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.NoError(t, nft.Add(nft.config.SetNameDrop, []string{"10.0.0.1", "10.0.1.0/24", "2001:db8::1"}))
+
+	assert.NoError(t, nft.Replace(config.Change{Networks: []string{"10.0.2.0/24"}}))
+	elements := mockConn.Elements[cfg.SetNameDrop]
+	if assert.Len(t, elements, 2) {
+		assert.Equal(t, []byte{10, 0, 2, 0}, elements[0].Key)
+	}
+	assert.Empty(t, mockConn.Elements[cfg.SetNameDrop+config.SetSuffixIPv6])
+
+	// при ошибке сеты не очищаются
+	messages := mockConn.Messages
+	assert.Error(t, nft.Replace(config.Change{Networks: []string{"invalid"}}))
+	assert.Equal(t, messages, mockConn.Messages)
+}
+
+func TestModifyBatch(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+
+	networks := make([]string, 0, 600)
+	for i := range 600 {
+		networks = append(networks, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+	}
+
+	assert.NoError(t, nft.Add(nft.config.SetNameDrop, networks))
+	assert.Equal(t, 3, mockConn.Messages)
+	assert.Len(t, mockConn.Elements[cfg.SetNameDrop], 1+len(networks)*2)
+}
+
+func TestTimeout(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.True(t, mockConn.Sets[0].HasTimeout)
+
+	err := nft.Apply([]config.Change{{Add: true, Networks: []string{"10.0.0.1"}, Timeout: 15 * time.Minute}})
+	assert.NoError(t, err)
+
+	elements := mockConn.Elements[cfg.SetNameDrop]
+	if assert.Len(t, elements, 3) {
+		assert.Equal(t, 15*time.Minute, elements[1].Timeout)
+	}
+
+	// ядро возвращает оставшееся время в Expires
+	mockConn.Elements[cfg.SetNameDrop] = []nftables.SetElement{
+		{Key: net.ParseIP("10.0.0.2").To4(), IntervalEnd: true},
+		{Key: net.ParseIP("10.0.0.1").To4(), Expires: 10 * time.Minute},
+	}
+	mockConn.Elements[cfg.SetNameDrop+config.SetSuffixIPv6] = nil
+
+	got, err := nft.ListElements(nft.config.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Element{{Network: "10.0.0.1", Expires: 10 * time.Minute}}, got)
+}
+
+func TestComment(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+
+	err := nft.Apply([]config.Change{{Add: true, Networks: []string{"10.0.0.1"}, Comment: "abuse ticket 123"}})
+	assert.NoError(t, err)
+
+	elements := mockConn.Elements[cfg.SetNameDrop]
+	if assert.Len(t, elements, 3) {
+		assert.Equal(t, "abuse ticket 123", elements[1].Comment)
+		assert.Empty(t, elements[2].Comment, "comment is stored on interval start only")
+	}
+
+	mockConn.Elements[cfg.SetNameDrop] = []nftables.SetElement{
+		{Key: net.ParseIP("10.0.0.2").To4(), IntervalEnd: true},
+		{Key: net.ParseIP("10.0.0.1").To4(), Comment: "abuse ticket 123"},
+	}
+	mockConn.Elements[cfg.SetNameDrop+config.SetSuffixIPv6] = nil
+
+	got, err := nft.ListElements(nft.config.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Element{{Network: "10.0.0.1", Comment: "abuse ticket 123"}}, got)
+}
+
+func TestListBothFamilies(t *testing.T) {
+	mockConn := NewMockNFTConn()
+	nft := NewMockNFT(cfg, mockConn)
+
+	// элементы возвращаются ядром в обратном порядке
+	mockConn.AddSet(&nftables.Set{Name: nft.config.SetNameDrop}, []nftables.SetElement{
+		{Key: net.ParseIP("10.0.1.0").To4(), IntervalEnd: true},
+		{Key: net.ParseIP("10.0.0.0").To4()},
+		{Key: make([]byte, net.IPv4len), IntervalEnd: true},
+	})
+	mockConn.AddSet(&nftables.Set{Name: nft.config.SetNameDrop + config.SetSuffixIPv6}, []nftables.SetElement{
+		{Key: net.ParseIP("2001:db9::"), IntervalEnd: true},
+		{Key: net.ParseIP("2001:db8::")},
+		{Key: make([]byte, net.IPv6len), IntervalEnd: true},
+	})
+
+	networks, err := nft.List(nft.config.SetNameDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/24", "2001:db8::/32"}, networks)
+}
+
 // Интеграционные тесты (требуют root)
 func TestIntegration(t *testing.T) {
 	if os.Getuid() != 0 {
@@ -153,7 +754,7 @@ func TestIntegration(t *testing.T) {
 	nft, err := New(cfg)
 	assert.NoError(t, err)
 	t.Run("CreateAndList", func(t *testing.T) {
-		nft.Create(false)
+		nft.Create(dropSpec(nft.config))
 		defer cleanup(t, nft)
 
 		// Проверка создания
@@ -164,17 +765,17 @@ func TestIntegration(t *testing.T) {
 
 	t.Run("AddRemoveIP", func(t *testing.T) {
 		testIP := "8.8.8.8"
-		nft.Create(false)
+		nft.Create(dropSpec(nft.config))
 		defer cleanup(t, nft)
 
 		// Добавление
-		nft.Modify(false, true, []string{testIP + "/32"})
+		nft.Modify(nft.config.SetNameDrop, true, []string{testIP + "/32"})
 		if !ipInSet(t, nft, testIP) {
 			t.Error("IP не добавлен")
 		}
 
 		// Удаление
-		nft.Modify(false, false, []string{testIP + "/32"})
+		nft.Modify(nft.config.SetNameDrop, false, []string{testIP + "/32"})
 		if ipInSet(t, nft, testIP) {
 			t.Error("IP не удалён")
 		}
@@ -184,7 +785,7 @@ func TestIntegration(t *testing.T) {
 func setExists(t *testing.T, nft *RealNFT) bool {
 	t.Helper()
 	table := nft.conn.AddTable(&nftables.Table{
-		Family: nftables.TableFamilyIPv4,
+		Family: nftables.TableFamilyINet,
 		Name:   nft.config.TableName,
 	})
 	_, err := nft.conn.GetSetByName(table, nft.config.SetNameDrop)
@@ -194,7 +795,7 @@ func setExists(t *testing.T, nft *RealNFT) bool {
 func ipInSet(t *testing.T, nft *RealNFT, ip string) bool {
 	t.Helper()
 	table := nft.conn.AddTable(&nftables.Table{
-		Family: nftables.TableFamilyIPv4,
+		Family: nftables.TableFamilyINet,
 		Name:   nft.config.TableName,
 	})
 	set, err := nft.conn.GetSetByName(table, nft.config.SetNameDrop)
@@ -217,10 +818,90 @@ func ipInSet(t *testing.T, nft *RealNFT, ip string) bool {
 
 func cleanup(t *testing.T, nft *RealNFT) {
 	nft.conn.DelTable(&nftables.Table{
-		Family: nftables.TableFamilyIPv4,
+		Family: nftables.TableFamilyINet,
 		Name:   nft.config.TableName,
 	})
 	if err := nft.conn.Flush(); err != nil {
 		t.Logf("Cleanup error: %v", err)
 	}
 }
+
+// dropSpec возвращает описание сета drop из настроек.
+func dropSpec(c config.Config) config.SetSpec {
+	spec := config.SetSpec{Name: c.SetNameDrop, Rule: c.RuleDrop}
+	if spec.Verdict == "" {
+		spec.Verdict = config.VerdictDrop
+	}
+	return spec
+}
+
+// acceptSpec возвращает описание сета accept из настроек.
+func acceptSpec(c config.Config) config.SetSpec {
+	spec := config.SetSpec{Name: c.SetNameAccept, Rule: c.RuleAccept}
+	if spec.Verdict == "" {
+		spec.Verdict = config.VerdictAccept
+	}
+	return spec
+}
+
+func TestRecorder(t *testing.T) {
+	rcfg := cfg
+	rcfg.RuleDrop = config.Rule{
+		Protocol:  config.ProtocolTCP,
+		Ports:     []uint16{22, 2222},
+		LogPrefix: "fwset: ",
+		LogLevel:  "info",
+		LogRate:   "10/minute",
+	}
+
+	mockConn := NewMockNFTConn()
+	var out strings.Builder
+	nft := NewMockNFT(rcfg, mockConn)
+	nft.conn = NewRecorder(mockConn, &out)
+
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Empty(t, mockConn.Tables, "nothing sent")
+
+	lines := strings.Split(out.String(), "\n")
+	assert.Equal(t, []string{
+		"add table inet test_table",
+		"add chain inet test_table input { type filter hook input priority 0; policy accept; }",
+		"add set inet test_table test_set { type ipv4_addr; flags interval,timeout; }",
+		`add rule inet test_table input meta nfproto ipv4 ip saddr @test_set meta l4proto tcp th dport { 22, 2222 } limit rate 10/minute log prefix "fwset: " level info comment "fwset:test_set:log"`,
+		`add rule inet test_table input meta nfproto ipv4 ip saddr @test_set meta l4proto tcp th dport { 22, 2222 } counter drop comment "fwset:test_set"`,
+		"add set inet test_table test_set6 { type ipv6_addr; flags interval,timeout; }",
+	}, lines[:6])
+
+	// изменения элементов используют существующие сеты
+	mockConn.AddTable(nft.table())
+	mockConn.AddSet(&nftables.Set{Name: "test_set", Table: nft.table()}, nil)
+	mockConn.AddSet(&nftables.Set{Name: "test_set6", Table: nft.table()}, nil)
+	out.Reset()
+
+	// чтение сетов ничего не выводит
+	_, err := nft.ListElements("test_set")
+	assert.NoError(t, err)
+	_, err = nft.List("test_set")
+	assert.NoError(t, err)
+	assert.Empty(t, out.String())
+
+	assert.NoError(t, nft.Apply([]config.Change{
+		{Set: "test_set", Add: true, Networks: []string{"10.0.0.0/24", "10.1.0.1-10.1.0.5"}, Timeout: 15 * time.Minute, Comment: "scan"},
+		{Set: "test_set", Networks: []string{"10.2.0.1"}},
+	}))
+	assert.Equal(t, `add element inet test_table test_set { 10.0.0.0/24 timeout 15m0s comment "scan", 10.1.0.1-10.1.0.5 timeout 15m0s comment "scan" }
+delete element inet test_table test_set { 10.2.0.1 }
+`, out.String())
+	assert.Empty(t, mockConn.Elements["test_set"])
+
+	out.Reset()
+	assert.NoError(t, nft.Destroy())
+	assert.Equal(t, "delete table inet test_table\n", out.String())
+
+	out.Reset()
+	nft.conn = NewRecorder(NewMockNFTConn(), &out)
+	nft.config.ChainHook = config.HookIngress
+	nft.config.ChainDevice = "eth0"
+	assert.NoError(t, nft.Create(dropSpec(nft.config)))
+	assert.Contains(t, out.String(), `type filter hook ingress device "eth0" priority 0;`)
+}
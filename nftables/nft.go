@@ -6,11 +6,17 @@ type NFT interface {
 	AddTable(t *nftables.Table) *nftables.Table
 	AddChain(c *nftables.Chain) *nftables.Chain
 	AddSet(s *nftables.Set, elements []nftables.SetElement) error
+	GetSets(t *nftables.Table) ([]*nftables.Set, error)
 	GetSetByName(t *nftables.Table, name string) (*nftables.Set, error)
 	SetAddElements(s *nftables.Set, elements []nftables.SetElement) error
 	SetDeleteElements(s *nftables.Set, elements []nftables.SetElement) error
+	FlushSet(s *nftables.Set)
 
+	GetRules(t *nftables.Table, c *nftables.Chain) ([]*nftables.Rule, error)
 	AddRule(r *nftables.Rule) *nftables.Rule
+	InsertRule(r *nftables.Rule) *nftables.Rule
+	DelRule(r *nftables.Rule) error
+	DelSet(s *nftables.Set)
 	Flush() error
 	GetSetElements(s *nftables.Set) ([]nftables.SetElement, error)
 	DelTable(t *nftables.Table)
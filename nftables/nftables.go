@@ -1,11 +1,22 @@
 package nftables
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"net/netip"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
 	"github.com/google/nftables/expr"
+	"github.com/google/nftables/userdata"
+	"golang.org/x/sys/unix"
 
 	"github.com/LeKovr/fwset/config"
 	"github.com/LeKovr/fwset/utils"
@@ -15,6 +26,68 @@ type Config struct {
 	config.Config
 }
 
+// family описывает параметры адресного семейства для сета и правила.
+type family struct {
+	suffix  string
+	nfproto byte
+	keyType nftables.SetDatatype
+	src     uint32 // смещение адреса источника в заголовке
+	dst     uint32 // смещение адреса назначения в заголовке
+	len     uint32
+	icmp    byte // номер протокола icmp для семейства
+}
+
+var (
+	familyIPv4 = family{
+		nfproto: unix.NFPROTO_IPV4,
+		keyType: nftables.TypeIPAddr,
+		src:     12,
+		dst:     16,
+		len:     net.IPv4len,
+		icmp:    unix.IPPROTO_ICMP,
+	}
+	familyIPv6 = family{
+		suffix:  config.SetSuffixIPv6,
+		nfproto: unix.NFPROTO_IPV6,
+		keyType: nftables.TypeIP6Addr,
+		src:     8,
+		dst:     24,
+		len:     net.IPv6len,
+		icmp:    unix.IPPROTO_ICMPV6,
+	}
+	families = []family{familyIPv4, familyIPv6}
+)
+
+// key возвращает адрес в формате ключа сета.
+func (f family) key(ip net.IP) []byte {
+	if f.len == net.IPv4len {
+		return ip.To4()
+	}
+
+	return ip.To16()
+}
+
+const (
+	// RuleTagPrefix - префикс комментария правил, созданных fwset.
+	RuleTagPrefix = "fwset:"
+
+	// LogTagSuffix добавляется к комментарию правила логирования с ограничением частоты.
+	LogTagSuffix = ":log"
+
+	// ElementsPerMessage ограничивает число элементов сета в одном сообщении netlink.
+	// Значение четное, чтобы начало и конец интервала попадали в одно сообщение.
+	ElementsPerMessage = 512
+)
+
+var (
+	// ErrNoRule возвращается, если в цепочке нет правила с handle из настроек.
+	ErrNoRule = errors.New("rule not found")
+
+	// ErrNoTimeout возвращается, если существующий сет создан без поддержки таймаутов (прежней версией).
+	// Такой сет нужно пересоздать: export, destroy, create, apply.
+	ErrNoTimeout = errors.New("set has no timeout support, recreate it")
+)
+
 type RealNFT struct {
 	config config.Config
 	conn   NFT
@@ -26,162 +99,925 @@ func New(cfg config.Config) (*RealNFT, error) {
 		return nil, err
 	}
 
+	if cfg.DryRun {
+		return &RealNFT{config: cfg, conn: NewRecorder(conn, os.Stdout)}, nil
+	}
+
 	return &RealNFT{
 		config: cfg,
 		conn:   conn,
 	}, nil
 }
 
-func (r *RealNFT) Create(accept bool) error {
+// Create создает таблицу, цепочку, сеты и правила, которых еще нет.
+// Повторный вызов не дублирует правила и не пересоздает существующие сеты.
+// Правила добавляются в позицию из настроек, по умолчанию - в конец цепочки.
+// Правила, которые отличаются от настроек (вердикт, логирование, условия, порты)
+// или стоят после правил сетов с меньшим приоритетом, заменяются.
+// Если задан Attach, таблица и цепочка должны существовать, создаются только сеты и правила.
+func (r *RealNFT) Create(spec config.SetSpec) error {
 	conn := r.conn
 
-	table := conn.AddTable(&nftables.Table{
-		Family: nftables.TableFamilyIPv4,
-		Name:   r.config.TableName,
-	})
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	if err := r.config.ValidateChain(); err != nil {
+		return err
+	}
+
+	// если таблицы или цепочки еще нет, получим ошибку и пустой список
+	sets, errSets := conn.GetSets(r.table())
+	rules, errRules := conn.GetRules(r.table(), &nftables.Chain{Name: r.config.ChainName})
+
+	table := r.table()
+	chain := &nftables.Chain{Name: r.config.ChainName, Table: table}
+
+	var reports []report // выводятся после успешного Flush
+
+	if r.config.Attach {
+		if errSets != nil {
+			return fmt.Errorf("table %s: %w", r.config.TableName, errSets)
+		}
+
+		if errRules != nil {
+			return fmt.Errorf("chain %s: %w", r.config.ChainName, errRules)
+		}
+	} else {
+		table = conn.AddTable(table)
+		reports = append(reports, report{"Table", r.config.TableName, created(errSets != nil)})
+
+		chain = conn.AddChain(r.baseChain(table))
+		reports = append(reports, report{"Chain", r.config.ChainName, created(errRules != nil)})
+
+		if _, err := conn.GetSets(r.legacyTable()); err == nil {
+			slog.Warn("Table of previous version found, its rules still apply. Move its networks and delete it",
+				"table", "ip "+r.config.TableName)
+		}
+	}
+
+	before, err := r.insertBefore(rules)
+	if err != nil {
+		return err
+	}
+
+	later, err := r.laterRule(rules, spec)
+	if err != nil {
+		return err
+	}
+
+	if spec.Verdict == config.VerdictJump && !r.config.NoRules {
+		// цепочка без хука, создается, если ее еще нет
+		conn.AddChain(&nftables.Chain{Name: spec.Jump, Table: table})
+	}
+
+	for _, fam := range families {
+		setName := spec.Name + fam.suffix
+
+		set := findSet(sets, setName)
+		if set != nil && !set.HasTimeout {
+			return fmt.Errorf("%w: %s", ErrNoTimeout, setName)
+		}
+
+		reports = append(reports, report{"Set", setName, created(set == nil)})
+
+		if set == nil {
+			set = &nftables.Set{
+				Name:       setName,
+				Table:      table,
+				KeyType:    fam.keyType,
+				Interval:   true,
+				HasTimeout: true, // для элементов с ограниченным временем жизни
+				// AutoMerge: true, // TODO: найти кейс, где это нужно
+			}
+			// See https://github.com/google/nftables/issues/247#issuecomment-1813787205
+			elements := []nftables.SetElement{
+				{
+					Key:         make([]byte, fam.len),
+					IntervalEnd: true,
+				},
+			}
+			if err := conn.AddSet(set, elements); err != nil {
+				return err
+			}
+		}
+
+		if r.config.NoRules {
+			slog.Info("Rule not added, reference the set in your chain", "set", "@"+setName)
+
+			continue
+		}
+
+		specs := ruleSpecs(fam, set, spec.Rule)
+
+		for _, rule := range specs {
+			rep, err := r.syncRule(rules, chain, rule, setName, before, later)
+			if err != nil {
+				return err
+			}
+
+			reports = append(reports, rep)
+		}
+
+		// отдельное правило логирования осталось от прежних настроек
+		if old := findRule(rules, ruleTag(setName)+LogTagSuffix, setName); old != nil && len(specs) == 1 {
+			if err := conn.DelRule(old); err != nil {
+				return err
+			}
+
+			reports = append(reports, report{"Rule", ruleTag(setName) + LogTagSuffix, statusRemoved})
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	for _, rep := range reports {
+		rep.log()
+	}
+
+	return nil
+}
+
+// syncRule добавляет правило, которого нет в цепочке, и заменяет правило, которое отличается
+// от настроек или стоит после правила later. Новое правило вставляется на место прежнего,
+// а правило не по порядку - перед later.
+func (r *RealNFT) syncRule(rules []*nftables.Rule, chain *nftables.Chain, rule ruleSpec, setName string,
+	before uint64, later *nftables.Rule,
+) (report, error) {
+	rep := report{"Rule", rule.tag, statusCreated}
+
+	pos := before
+	if later != nil && (before == 0 || ruleIndex(rules, later.Handle) < ruleIndex(rules, before)) {
+		pos = later.Handle
+	}
+
+	old := findRule(rules, rule.tag, setName)
+	if old != nil {
+		same, err := r.sameRule(old, rule)
+		if err != nil {
+			return rep, err
+		}
+
+		inOrder := later == nil || ruleIndex(rules, old.Handle) < ruleIndex(rules, later.Handle)
+		if same && inOrder {
+			rep.status = statusPresent
+
+			return rep, nil
+		}
+
+		rep.status = statusReplaced
+
+		if inOrder {
+			pos = old.Handle
+		}
+	}
+
+	if rule.ports != nil {
+		if err := rule.ports.add(r.conn, chain.Table); err != nil {
+			return rep, err
+		}
+	}
+
+	nfRule := &nftables.Rule{
+		Table:    chain.Table,
+		Chain:    chain,
+		Exprs:    rule.exprs,
+		UserData: userdata.AppendString(nil, userdata.TypeComment, rule.tag),
+	}
+
+	if pos == 0 {
+		r.conn.AddRule(nfRule)
+	} else {
+		// правила вставляются перед одним и тем же правилом, поэтому их порядок сохраняется
+		nfRule.Position = pos
+		r.conn.InsertRule(nfRule)
+	}
 
-	conn.AddChain(&nftables.Chain{
+	if old != nil {
+		if err := r.conn.DelRule(old); err != nil {
+			return rep, err
+		}
+	}
+
+	return rep, nil
+}
+
+// laterRule возвращает первое в цепочке правило сета, который в SetSpecs идет после spec.
+// Правила spec должны стоять перед ним, иначе порядок приоритета нарушен.
+func (r *RealNFT) laterRule(rules []*nftables.Rule, spec config.SetSpec) (*nftables.Rule, error) {
+	specs, err := r.config.SetSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	i := slices.IndexFunc(specs, func(s config.SetSpec) bool { return s.Name == spec.Name })
+	if i < 0 {
+		return nil, nil
+	}
+
+	tags := make(map[string]bool)
+
+	for _, s := range specs[i+1:] {
+		for _, fam := range families {
+			tag := ruleTag(s.Name + fam.suffix)
+			tags[tag] = true
+			tags[tag+LogTagSuffix] = true
+		}
+	}
+
+	for _, rule := range rules {
+		if comment, ok := userdata.GetString(rule.UserData, userdata.TypeComment); ok && tags[comment] {
+			return rule, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ruleIndex возвращает номер правила с handle в списке правил цепочки.
+func ruleIndex(rules []*nftables.Rule, handle uint64) int {
+	return slices.IndexFunc(rules, func(rule *nftables.Rule) bool { return rule.Handle == handle })
+}
+
+// sameRule сравнивает правило цепочки с правилом из настроек: комментарий, выражения
+// и порты анонимного сета. Значения счетчиков и ID сетов не сравниваются.
+func (r *RealNFT) sameRule(old *nftables.Rule, rule ruleSpec) (bool, error) {
+	if comment, _ := userdata.GetString(old.UserData, userdata.TypeComment); comment != rule.tag || len(old.Exprs) != len(rule.exprs) {
+		return false, nil
+	}
+
+	for i, e := range rule.exprs {
+		if rule.ports != nil && e == expr.Any(rule.ports.lookup) {
+			lookup, ok := old.Exprs[i].(*expr.Lookup)
+			if !ok {
+				return false, nil
+			}
+
+			if same, err := r.samePorts(lookup.SetName, rule.ports.elements); err != nil || !same {
+				return false, err
+			}
+
+			continue
+		}
+
+		if !sameExpr(e, old.Exprs[i]) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// samePorts сравнивает элементы анонимного сета портов с портами из настроек.
+func (r *RealNFT) samePorts(name string, want []nftables.SetElement) (bool, error) {
+	set, err := r.conn.GetSetByName(r.table(), name)
+	if err != nil {
+		return false, err
+	}
+
+	elements, err := r.conn.GetSetElements(set)
+	if err != nil {
+		return false, err
+	}
+
+	keys := func(elements []nftables.SetElement) []string {
+		rv := make([]string, len(elements))
+		for i, e := range elements {
+			rv[i] = string(e.Key)
+		}
+
+		slices.Sort(rv)
+
+		return rv
+	}
+
+	return slices.Equal(keys(elements), keys(want)), nil
+}
+
+// sameExpr сравнивает выражения правил без учета состояния (значений счетчика) и ID сетов.
+func sameExpr(want, got expr.Any) bool {
+	switch w := want.(type) {
+	case *expr.Counter:
+		_, ok := got.(*expr.Counter)
+
+		return ok
+	case *expr.Lookup:
+		g, ok := got.(*expr.Lookup)
+
+		return ok && w.SetName == g.SetName && w.SourceRegister == g.SourceRegister && w.Invert == g.Invert
+	case *expr.Log:
+		g, ok := got.(*expr.Log)
+
+		return ok && w.Level == g.Level && bytes.Equal(w.Data, g.Data)
+	case *expr.Limit:
+		g, ok := got.(*expr.Limit)
+
+		return ok && w.Type == g.Type && w.Rate == g.Rate && w.Unit == g.Unit && w.Over == g.Over
+	default:
+		return reflect.DeepEqual(want, got)
+	}
+}
+
+// insertBefore возвращает handle правила, перед которым добавляются правила fwset, 0 - в конец цепочки.
+// Для first это первое правило, созданное не fwset, чтобы сеты сохраняли порядок приоритета.
+func (r *RealNFT) insertBefore(rules []*nftables.Rule) (uint64, error) {
+	handle, err := r.config.PositionHandle()
+	if err != nil {
+		return 0, err
+	}
+
+	if r.config.Position == config.PositionFirst {
+		for _, rule := range rules {
+			if comment, ok := userdata.GetString(rule.UserData, userdata.TypeComment); !ok || !strings.HasPrefix(comment, RuleTagPrefix) {
+				return rule.Handle, nil
+			}
+		}
+
+		return 0, nil
+	}
+
+	if handle == 0 {
+		return 0, nil
+	}
+
+	for _, rule := range rules {
+		if rule.Handle == handle {
+			return handle, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %d", ErrNoRule, handle)
+}
+
+// hookInetIngress - хук ingress в таблице inet (NF_INET_INGRESS, в x/sys/unix его нет).
+// nftables.ChainHookIngress - это NF_NETDEV_INGRESS = 0, в inet так нумеруется prerouting.
+const hookInetIngress = nftables.ChainHook(unix.NF_INET_NUMHOOKS)
+
+// hooks - хуки базовой цепочки.
+var hooks = map[string]*nftables.ChainHook{
+	config.HookInput:       nftables.ChainHookInput,
+	config.HookForward:     nftables.ChainHookForward,
+	config.HookOutput:      nftables.ChainHookOutput,
+	config.HookPrerouting:  nftables.ChainHookPrerouting,
+	config.HookPostrouting: nftables.ChainHookPostrouting,
+	config.HookIngress:     nftables.ChainHookRef(hookInetIngress),
+}
+
+// baseChain возвращает базовую цепочку с хуком, приоритетом и политикой из настроек.
+// Настройки проверены в config.ValidateChain, по умолчанию используется хук input.
+func (r *RealNFT) baseChain(table *nftables.Table) *nftables.Chain {
+	hook, ok := hooks[r.config.ChainHook]
+	if !ok {
+		hook = nftables.ChainHookInput
+	}
+
+	policy := nftables.ChainPolicyAccept
+	if r.config.ChainPolicy == config.VerdictDrop {
+		policy = nftables.ChainPolicyDrop
+	}
+
+	return &nftables.Chain{
 		Name:     r.config.ChainName,
 		Table:    table,
 		Type:     nftables.ChainTypeFilter,
-		Hooknum:  nftables.ChainHookInput, // TODO: проверить ChainHookIngress
-		Priority: nftables.ChainPriorityFilter,
-	})
+		Hooknum:  hook,
+		Priority: nftables.ChainPriorityRef(nftables.ChainPriority(r.config.ChainPriority)), //nolint:gosec // приоритет задается в настройках
+		Policy:   &policy,
+		Device:   r.config.ChainDevice,
+	}
+}
 
-	kind := expr.VerdictDrop
-	setName := r.config.SetNameDrop
+// ruleSpec описывает правило fwset: выражения и комментарий, по которому правило находится.
+type ruleSpec struct {
+	tag   string
+	exprs []expr.Any
+	ports *portSet // анонимный сет портов, создается вместе с правилом
+}
 
-	if accept {
-		kind = expr.VerdictAccept
-		setName = r.config.SetNameAccept
+// portSet описывает анонимный сет портов назначения и выражение, которое его использует.
+// Имя и ID анонимного сета назначаются при добавлении, поэтому lookup заполняется в add.
+type portSet struct {
+	set      *nftables.Set
+	elements []nftables.SetElement
+	lookup   *expr.Lookup
+}
+
+// add добавляет сет портов в таблицу.
+func (ps *portSet) add(conn NFT, table *nftables.Table) error {
+	ps.set.Table = table
+	if err := conn.AddSet(ps.set, ps.elements); err != nil {
+		return err
 	}
 
-	set := &nftables.Set{
-		Name:     setName,
-		Table:    table,
-		KeyType:  nftables.TypeIPAddr,
-		Interval: true,
-		// AutoMerge: true, // TODO: найти кейс, где это нужно
-	}
-	// See https://github.com/google/nftables/issues/247#issuecomment-1813787205
-	elements := []nftables.SetElement{
-		{
-			Key:         []byte{0x00, 0x00, 0x00, 0x00},
-			IntervalEnd: true,
-		},
+	ps.lookup.SetName = ps.set.Name
+	ps.lookup.SetID = ps.set.ID
+
+	return nil
+}
+
+// ruleSpecs возвращает правила, проверяющие адрес пакета по сету.
+// Логирование с ограничением частоты выполняется отдельным правилом перед основным,
+// т.к. limit прерывает правило и пакеты сверх лимита не дошли бы до вердикта.
+func ruleSpecs(fam family, set *nftables.Set, spec config.Rule) []ruleSpec {
+	var rv []ruleSpec
+
+	logRule := !spec.NoLog && spec.LogRate != ""
+	if logRule {
+		count, unit, _ := spec.Rate() // формат проверен в config.Rule
+		exprs, ports := matchExprs(fam, set, spec)
+		rv = append(rv, ruleSpec{
+			tag: ruleTag(set.Name) + LogTagSuffix,
+			exprs: append(exprs,
+				&expr.Limit{
+					Type: expr.LimitTypePkts,
+					Rate: count,
+					Unit: expr.LimitTime(config.RateUnits[unit]),
+				},
+				logExpr(spec),
+			),
+			ports: ports,
+		})
 	}
-	if err := conn.AddSet(set, elements); err != nil {
-		return err
+
+	exprs, ports := matchExprs(fam, set, spec)
+	if spec.Verdict == config.VerdictReject && spec.RejectWith == config.RejectTCPReset && spec.Protocol == "" {
+		// tcp reset возможен только для TCP
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     []byte{unix.IPPROTO_TCP},
+			},
+		)
+	}
+
+	if !spec.NoCounter {
+		exprs = append(exprs, &expr.Counter{})
+	}
+
+	if !spec.NoLog && !logRule {
+		exprs = append(exprs, logExpr(spec))
 	}
 
-	exprs := []expr.Any{
+	rv = append(rv, ruleSpec{
+		tag:   ruleTag(set.Name),
+		exprs: append(exprs, verdictExpr(spec)),
+		ports: ports,
+	})
+
+	return rv
+}
+
+// matchExprs возвращает выражения, проверяющие адрес источника или назначения по сету,
+// протокол и порт назначения. Для нескольких портов возвращается анонимный сет, который нужно создать с правилом.
+func matchExprs(fam family, set *nftables.Set, spec config.Rule) ([]expr.Any, *portSet) {
+	offset := fam.src
+	if spec.Match == config.MatchDst {
+		offset = fam.dst
+	}
+
+	rv := []expr.Any{
+		// в таблице inet проверяем семейство пакета до загрузки адреса
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{fam.nfproto},
+		},
 		&expr.Payload{
 			DestRegister: 1,
 			Base:         expr.PayloadBaseNetworkHeader,
-			Offset:       12,
-			Len:          4,
+			Offset:       offset,
+			Len:          fam.len,
 		},
 		&expr.Lookup{
 			SourceRegister: 1,
-			SetName:        setName,
+			SetName:        set.Name,
 			SetID:          set.ID,
 		},
-		/*
-		   TODO: Что это добавит?
-		           &expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
-		           &expr.Cmp{
-		                   Op:       expr.CmpOpEq,
-		                   Register: 1,
-		                   Data:     []byte{unix.IPPROTO_TCP},
-		           },
-		*/
-		&expr.Counter{}, // TODO: вынести в config
-		&expr.Log{},     // TODO: вынести в config
-		&expr.Verdict{Kind: kind},
-	}
-
-	conn.AddRule(&nftables.Rule{
-		Table: table,
-		Chain: &nftables.Chain{Name: r.config.ChainName},
-		Exprs: exprs,
+	}
+
+	if spec.Protocol == "" {
+		return rv, nil
+	}
+
+	proto := fam.icmp
+	switch spec.Protocol {
+	case config.ProtocolTCP:
+		proto = unix.IPPROTO_TCP
+	case config.ProtocolUDP:
+		proto = unix.IPPROTO_UDP
+	}
+
+	rv = append(rv,
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{proto},
+		},
+	)
+
+	if len(spec.Ports) == 0 {
+		return rv, nil
+	}
+
+	// порт назначения tcp и udp - 2 байта по смещению 2
+	rv = append(rv, &expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseTransportHeader,
+		Offset:       2,
+		Len:          2,
 	})
 
-	return conn.Flush()
+	if len(spec.Ports) == 1 {
+		return append(rv, &expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     binaryutil.BigEndian.PutUint16(spec.Ports[0]),
+		}), nil
+	}
+
+	ports := &portSet{
+		set: &nftables.Set{
+			Anonymous: true,
+			Constant:  true,
+			KeyType:   nftables.TypeInetService,
+		},
+		lookup: &expr.Lookup{SourceRegister: 1},
+	}
+
+	for _, port := range spec.Ports {
+		ports.elements = append(ports.elements, nftables.SetElement{Key: binaryutil.BigEndian.PutUint16(port)})
+	}
+
+	return append(rv, ports.lookup), ports
 }
 
-func (r *RealNFT) Destroy() error {
-	r.conn.DelTable(&nftables.Table{
-		Family: nftables.TableFamilyIPv4,
-		Name:   r.config.TableName,
-	})
+// logExpr возвращает выражение логирования с уровнем и префиксом.
+func logExpr(spec config.Rule) *expr.Log {
+	rv := &expr.Log{
+		Level: expr.LogLevel(spec.Level()), //nolint:gosec // индекс LogLevels
+		Key:   1 << unix.NFTA_LOG_LEVEL,
+	}
 
-	return r.conn.Flush()
+	if spec.LogPrefix != "" {
+		rv.Key |= 1 << unix.NFTA_LOG_PREFIX
+		rv.Data = []byte(spec.LogPrefix)
+	}
+
+	return rv
+}
+
+// rejectCodes - коды icmpx для reject в таблице inet.
+var rejectCodes = map[string]uint8{
+	config.RejectPortUnreachable: unix.NFT_REJECT_ICMPX_PORT_UNREACH,
+	config.RejectHostUnreachable: unix.NFT_REJECT_ICMPX_HOST_UNREACH,
+	config.RejectNoRoute:         unix.NFT_REJECT_ICMPX_NO_ROUTE,
+	config.RejectAdminProhibited: unix.NFT_REJECT_ICMPX_ADMIN_PROHIBITED,
+}
+
+// verdictExpr возвращает выражение вердикта правила.
+func verdictExpr(spec config.Rule) expr.Any {
+	switch spec.Verdict {
+	case config.VerdictAccept:
+		return &expr.Verdict{Kind: expr.VerdictAccept}
+	case config.VerdictReject:
+		if spec.RejectWith == config.RejectTCPReset {
+			return &expr.Reject{Type: unix.NFT_REJECT_TCP_RST}
+		}
+
+		return &expr.Reject{Type: unix.NFT_REJECT_ICMPX_UNREACH, Code: rejectCodes[spec.RejectWith]}
+	case config.VerdictJump:
+		return &expr.Verdict{Kind: expr.VerdictJump, Chain: spec.Jump}
+	default:
+		return &expr.Verdict{Kind: expr.VerdictDrop}
+	}
+}
+
+// ruleTag возвращает комментарий, которым помечаются правила fwset.
+func ruleTag(setName string) string {
+	return RuleTagPrefix + setName
+}
+
+func findSet(sets []*nftables.Set, name string) *nftables.Set {
+	for _, set := range sets {
+		if set.Name == name {
+			return set
+		}
+	}
+
+	return nil
+}
+
+// findRule ищет правило по комментарию, а правила без него (созданные ранее) - по сету в lookup.
+func findRule(rules []*nftables.Rule, tag, setName string) *nftables.Rule {
+	for _, rule := range rules {
+		if comment, ok := userdata.GetString(rule.UserData, userdata.TypeComment); ok {
+			if comment == tag {
+				return rule
+			}
+
+			continue
+		}
+
+		if tag != ruleTag(setName) {
+			// правила логирования без комментария не создавались
+			continue
+		}
+
+		for _, e := range rule.Exprs {
+			if lookup, ok := e.(*expr.Lookup); ok && lookup.SetName == setName {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
+
+// Состояния объектов в сообщениях Create.
+const (
+	statusCreated  = "created"
+	statusPresent  = "already present"
+	statusReplaced = "replaced"
+	statusRemoved  = "removed"
+)
+
+// report описывает объект, который Create создал, нашел, заменил или удалил.
+type report struct {
+	kind   string
+	name   string
+	status string
 }
 
-func (r *RealNFT) Modify(accept, add bool, networks []string) error {
+// created возвращает состояние созданного или уже существовавшего объекта.
+func created(isNew bool) string {
+	if isNew {
+		return statusCreated
+	}
+
+	return statusPresent
+}
+
+// log сообщает, что произошло с объектом.
+func (rep report) log() {
+	slog.Info(rep.kind+" "+rep.status, "name", rep.name)
+}
+
+// Destroy удаляет таблицу (и таблицу прежних версий, см. legacyTable), а если задан Attach - только правила и сеты fwset.
+// Правила fwset находятся по комментарию, сет не удалится, пока на него ссылаются другие правила.
+func (r *RealNFT) Destroy() error {
 	conn := r.conn
-	table := conn.AddTable(&nftables.Table{
-		Family: nftables.TableFamilyIPv4,
-		Name:   r.config.TableName,
-	})
 
-	setName := r.config.SetNameDrop
-	if accept {
-		setName = r.config.SetNameAccept
+	if !r.config.Attach {
+		conn.DelTable(r.table())
+
+		if _, err := conn.GetSets(r.legacyTable()); err == nil {
+			conn.DelTable(r.legacyTable())
+		}
+
+		return conn.Flush()
 	}
 
-	set, err := conn.GetSetByName(table, setName)
+	specs, err := r.config.SetSpecs()
 	if err != nil {
 		return err
 	}
 
-	for _, network := range networks {
-		firstIP, lastIP, err := utils.CIDRToRange(network)
-		if err != nil {
-			return err
+	table := r.table()
+
+	sets, err := conn.GetSets(table)
+	if err != nil {
+		return err
+	}
+
+	rules, err := conn.GetRules(table, &nftables.Chain{Name: r.config.ChainName, Table: table})
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]bool)
+
+	for _, spec := range specs {
+		for _, fam := range families {
+			tag := ruleTag(spec.Name + fam.suffix)
+			tags[tag] = true
+			tags[tag+LogTagSuffix] = true
 		}
+	}
 
-		lastIP = utils.NextIP(lastIP) // для диапазона нужен следующий за крайним ip
+	for _, rule := range rules {
+		if comment, ok := userdata.GetString(rule.UserData, userdata.TypeComment); ok && tags[comment] {
+			if err := conn.DelRule(rule); err != nil {
+				return err
+			}
+		}
+	}
 
-		elements := []nftables.SetElement{
-			{Key: []byte(firstIP.To4())},
-			{Key: lastIP.To4(), IntervalEnd: true},
+	for _, spec := range specs {
+		for _, fam := range families {
+			if set := findSet(sets, spec.Name+fam.suffix); set != nil {
+				conn.DelSet(set)
+			}
 		}
+	}
 
-		if add {
-			if err := conn.SetAddElements(set, elements); err != nil {
+	return conn.Flush()
+}
+
+func (r *RealNFT) Modify(set string, add bool, networks []string) error {
+	return r.Apply([]config.Change{{Set: set, Add: add, Networks: networks}})
+}
+
+// Apply выполняет все изменения в одной транзакции nftables: сообщения отправляются одним пакетом
+// при Flush, и ядро применяет либо все изменения, либо ни одного.
+// Сети и сеты проверяются до постановки сообщений в очередь, чтобы при ошибке
+// в соединении не осталось неотправленных сообщений.
+func (r *RealNFT) Apply(changes []config.Change) error {
+	table := r.table()
+	sets := make(map[string]*nftables.Set, len(families)*2)
+
+	batches := make([][]setElements, len(changes))
+	for i, change := range changes {
+		var err error
+		if batches[i], err = r.prepare(table, sets, change); err != nil {
+			return err
+		}
+	}
+
+	for i, change := range changes {
+		for _, batch := range batches[i] {
+			if err := r.queue(batch.set, change.Add, batch.elements); err != nil {
 				return err
 			}
-		} else {
-			if err := conn.SetDeleteElements(set, elements); err != nil {
+		}
+	}
+
+	return r.conn.Flush()
+}
+
+// Replace заменяет содержимое сетов IPv4 и IPv6 сетями изменения.
+// Очистка и заполнение сетов выполняются одной транзакцией, поэтому сет не бывает пустым.
+func (r *RealNFT) Replace(change config.Change) error {
+	change.Add = true
+
+	table := r.table()
+	sets := make(map[string]*nftables.Set, len(families))
+
+	batches, err := r.prepare(table, sets, change)
+	if err != nil {
+		return err
+	}
+
+	flush := make([]*nftables.Set, 0, len(families))
+
+	for _, fam := range families {
+		setName := r.config.ChangeSet(change) + fam.suffix
+
+		set, ok := sets[setName]
+		if !ok {
+			if set, err = r.conn.GetSetByName(table, setName); err != nil {
 				return err
 			}
 		}
+
+		flush = append(flush, set)
 	}
 
-	return conn.Flush()
+	for _, set := range flush {
+		r.conn.FlushSet(set)
+	}
+
+	for _, batch := range batches {
+		if err := r.queue(batch.set, true, batch.elements); err != nil {
+			return err
+		}
+	}
+
+	return r.conn.Flush()
+}
+
+// setElements - элементы одного сета из изменения.
+type setElements struct {
+	set      *nftables.Set
+	elements []nftables.SetElement
+}
+
+// prepare разбирает сети изменения и группирует элементы по сетам.
+func (r *RealNFT) prepare(table *nftables.Table, sets map[string]*nftables.Set, change config.Change) ([]setElements, error) {
+	var names []string // порядок сетов для воспроизводимости
+
+	elements := make(map[string][]nftables.SetElement)
+
+	for _, network := range change.Networks {
+		firstIP, lastIP, err := utils.CIDRToRange(network)
+		if err != nil {
+			return nil, err
+		}
+
+		fam := familyIPv4
+		if firstIP.To4() == nil {
+			fam = familyIPv6
+		}
+
+		setName := r.config.ChangeSet(change) + fam.suffix
+
+		if _, ok := sets[setName]; !ok {
+			set, err := r.conn.GetSetByName(table, setName)
+			if err != nil {
+				return nil, err
+			}
+
+			sets[setName] = set
+		}
+
+		if _, ok := elements[setName]; !ok {
+			names = append(names, setName)
+		}
+
+		lastIP = utils.NextIP(lastIP) // для диапазона нужен следующий за крайним ip
+
+		elements[setName] = append(elements[setName],
+			nftables.SetElement{Key: fam.key(firstIP), Timeout: change.Timeout, Comment: change.Comment},
+			nftables.SetElement{Key: fam.key(lastIP), IntervalEnd: true, Timeout: change.Timeout},
+		)
+	}
+
+	rv := make([]setElements, len(names))
+	for i, setName := range names {
+		rv[i] = setElements{set: sets[setName], elements: elements[setName]}
+	}
+
+	return rv, nil
 }
 
-func (r *RealNFT) Add(accept bool, networks []string) error {
-	return r.Modify(accept, true, networks)
+// queue добавляет элементы в очередь сообщений, которые будут отправлены при Flush.
+// Одно сообщение содержит не больше ElementsPerMessage элементов.
+func (r *RealNFT) queue(set *nftables.Set, add bool, elements []nftables.SetElement) error {
+	for chunk := range slices.Chunk(elements, ElementsPerMessage) {
+		var err error
+		if add {
+			err = r.conn.SetAddElements(set, chunk)
+		} else {
+			err = r.conn.SetDeleteElements(set, chunk)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (r *RealNFT) Remove(accept bool, networks []string) error {
-	return r.Modify(accept, false, networks)
+func (r *RealNFT) Add(set string, networks []string) error {
+	return r.Modify(set, true, networks)
 }
 
-func (r *RealNFT) List(accept bool) ([]string, error) {
-	conn := r.conn
-	table := conn.AddTable(&nftables.Table{
-		Family: nftables.TableFamilyIPv4,
-		Name:   r.config.TableName,
-	})
+func (r *RealNFT) Remove(set string, networks []string) error {
+	return r.Modify(set, false, networks)
+}
+
+func (r *RealNFT) List(set string) ([]string, error) {
+	elements, err := r.ListElements(set)
+	if err != nil {
+		return nil, err
+	}
 
-	setName := r.config.SetNameDrop
-	if accept {
-		setName = r.config.SetNameAccept
+	networks := make([]string, len(elements))
+	for i, elem := range elements {
+		networks[i] = elem.Network
 	}
 
+	return networks, nil
+}
+
+// ListElements возвращает элементы IPv4 и IPv6 сетов.
+// Таблица не добавляется в пакет изменений (AddTable), поэтому чтение не попадает в вывод dry run.
+func (r *RealNFT) ListElements(set string) ([]config.Element, error) {
+	table := r.table()
+
+	var elements []config.Element
+
+	for _, fam := range families {
+		elems, err := r.listSet(table, set+fam.suffix)
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, elems...)
+	}
+
+	return elements, nil
+}
+
+func (r *RealNFT) listSet(table *nftables.Table, setName string) ([]config.Element, error) {
+	conn := r.conn
+
 	set, err := conn.GetSetByName(table, setName)
 	if err != nil {
 		return nil, err
@@ -194,33 +1030,46 @@ func (r *RealNFT) List(accept bool) ([]string, error) {
 
 	var end net.IP
 
-	var networks []string
+	var rv []config.Element
 
 	for _, elem := range elements {
 		// Преобразование обратно в CIDR
 		if elem.IntervalEnd {
 			end = net.IP(elem.Key)
-			// последний элемент - 0.0.0.0 с IntervalEnd, будетнеявно проигнорирован
+			// последний элемент - 0.0.0.0 (::) с IntervalEnd, будет неявно проигнорирован
 			continue
 		}
 
-		start := net.IP(elem.Key)
-		end = utils.PreviousIP(end)
-		// fmt.Printf("%s .. %s\n", start, end)
+		start, _ := netip.AddrFromSlice(elem.Key)
+		last, _ := netip.AddrFromSlice(utils.PreviousIP(end))
 
-		nets, err := utils.IPRangeToCIDR(nil, start.String(), end.String())
-		if err != nil {
+		if !start.IsValid() || !last.IsValid() {
 			// TODO: log err
 			continue
 		}
 
-		if len(nets) > 1 {
-			// для нас диапазон будет лучше
-			nets = []string{fmt.Sprintf("%s-%s", start, end)}
-		}
+		rv = append(rv, config.Element{
+			Network: utils.IPRange{Start: start, End: last}.String(),
+			Expires: elem.Expires,
+			Comment: elem.Comment,
+		})
+	}
+
+	return rv, nil
+}
 
-		networks = append(networks, nets...)
+func (r *RealNFT) table() *nftables.Table {
+	return &nftables.Table{
+		Family: nftables.TableFamilyINet,
+		Name:   r.config.TableName,
 	}
+}
 
-	return networks, nil
+// legacyTable возвращает таблицу семейства ip, которую создавали версии без поддержки IPv6.
+// После обновления она остается рядом с таблицей inet, и ее правила продолжают работать.
+func (r *RealNFT) legacyTable() *nftables.Table {
+	return &nftables.Table{
+		Family: nftables.TableFamilyIPv4,
+		Name:   r.config.TableName,
+	}
 }
@@ -0,0 +1,409 @@
+package nftables
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/google/nftables/userdata"
+	"golang.org/x/sys/unix"
+
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/utils"
+)
+
+// Recorder выводит изменения в синтаксисе nft вместо отправки в ядро.
+// Чтение (сеты, правила, элементы) выполняется через исходное соединение.
+type Recorder struct {
+	conn NFT
+	w    io.Writer
+	anon map[string][]nftables.SetElement // элементы анонимных сетов выводятся в правиле
+}
+
+// NewRecorder возвращает соединение для режима dry run.
+func NewRecorder(conn NFT, w io.Writer) *Recorder {
+	return &Recorder{conn: conn, w: w, anon: make(map[string][]nftables.SetElement)}
+}
+
+func (r *Recorder) AddTable(t *nftables.Table) *nftables.Table {
+	r.printf("add table %s", tableRef(t))
+
+	return t
+}
+
+func (r *Recorder) DelTable(t *nftables.Table) {
+	r.printf("delete table %s", tableRef(t))
+}
+
+func (r *Recorder) AddChain(c *nftables.Chain) *nftables.Chain {
+	if c.Hooknum == nil {
+		r.printf("add chain %s %s", tableRef(c.Table), c.Name)
+
+		return c
+	}
+
+	hook := strconv.FormatUint(uint64(*c.Hooknum), 10)
+
+	for name, h := range hooks {
+		if *h == *c.Hooknum {
+			hook = name
+		}
+	}
+
+	if c.Device != "" {
+		hook += " device " + strconv.Quote(c.Device)
+	}
+
+	var priority nftables.ChainPriority
+	if c.Priority != nil {
+		priority = *c.Priority
+	}
+
+	policy := "accept"
+	if c.Policy != nil && *c.Policy == nftables.ChainPolicyDrop {
+		policy = "drop"
+	}
+
+	r.printf("add chain %s %s { type filter hook %s priority %d; policy %s; }", tableRef(c.Table), c.Name, hook, priority, policy)
+
+	return c
+}
+
+func (r *Recorder) AddSet(s *nftables.Set, elements []nftables.SetElement) error {
+	if s.Anonymous {
+		// имя назначается так же, как при отправке в ядро
+		s.ID = uint32(len(r.anon) + 1) //nolint:gosec // число анонимных сетов невелико
+		s.Name = fmt.Sprintf("__set%d", s.ID)
+		r.anon[s.Name] = elements
+
+		return nil
+	}
+
+	flags := []string{}
+	if s.Interval {
+		flags = append(flags, "interval")
+	}
+
+	if s.HasTimeout {
+		flags = append(flags, "timeout")
+	}
+
+	r.printf("add set %s %s { type %s; flags %s; }", tableRef(s.Table), s.Name, s.KeyType.Name, strings.Join(flags, ","))
+
+	if elems := formatElements(elements); elems != "" {
+		r.printf("add element %s %s { %s }", tableRef(s.Table), s.Name, elems)
+	}
+
+	return nil
+}
+
+func (r *Recorder) DelSet(s *nftables.Set) {
+	r.printf("delete set %s %s", tableRef(s.Table), s.Name)
+}
+
+func (r *Recorder) GetSets(t *nftables.Table) ([]*nftables.Set, error) {
+	return r.conn.GetSets(t)
+}
+
+func (r *Recorder) GetSetByName(t *nftables.Table, name string) (*nftables.Set, error) {
+	return r.conn.GetSetByName(t, name)
+}
+
+func (r *Recorder) GetSetElements(s *nftables.Set) ([]nftables.SetElement, error) {
+	return r.conn.GetSetElements(s)
+}
+
+func (r *Recorder) SetAddElements(s *nftables.Set, elements []nftables.SetElement) error {
+	r.printf("add element %s %s { %s }", tableRef(s.Table), s.Name, formatElements(elements))
+
+	return nil
+}
+
+func (r *Recorder) SetDeleteElements(s *nftables.Set, elements []nftables.SetElement) error {
+	r.printf("delete element %s %s { %s }", tableRef(s.Table), s.Name, formatElements(elements))
+
+	return nil
+}
+
+func (r *Recorder) FlushSet(s *nftables.Set) {
+	r.printf("flush set %s %s", tableRef(s.Table), s.Name)
+}
+
+func (r *Recorder) GetRules(t *nftables.Table, c *nftables.Chain) ([]*nftables.Rule, error) {
+	return r.conn.GetRules(t, c)
+}
+
+func (r *Recorder) AddRule(rule *nftables.Rule) *nftables.Rule {
+	position := ""
+	if rule.Position != 0 {
+		position = fmt.Sprintf(" position %d", rule.Position)
+	}
+
+	r.printf("add rule %s %s%s %s", tableRef(rule.Table), rule.Chain.Name, position, r.formatRule(rule))
+
+	return rule
+}
+
+func (r *Recorder) InsertRule(rule *nftables.Rule) *nftables.Rule {
+	position := ""
+	if rule.Position != 0 {
+		position = fmt.Sprintf(" position %d", rule.Position)
+	}
+
+	r.printf("insert rule %s %s%s %s", tableRef(rule.Table), rule.Chain.Name, position, r.formatRule(rule))
+
+	return rule
+}
+
+func (r *Recorder) DelRule(rule *nftables.Rule) error {
+	r.printf("delete rule %s %s handle %d", tableRef(rule.Table), rule.Chain.Name, rule.Handle)
+
+	return nil
+}
+
+// Flush ничего не отправляет.
+func (r *Recorder) Flush() error {
+	return nil
+}
+
+func (r *Recorder) printf(format string, args ...any) {
+	fmt.Fprintf(r.w, format+"\n", args...)
+}
+
+// tableFamilies - имена семейств таблиц в синтаксисе nft.
+var tableFamilies = map[nftables.TableFamily]string{
+	nftables.TableFamilyINet:   "inet",
+	nftables.TableFamilyIPv4:   "ip",
+	nftables.TableFamilyIPv6:   "ip6",
+	nftables.TableFamilyNetdev: "netdev",
+}
+
+func tableRef(t *nftables.Table) string {
+	if t == nil {
+		return "?"
+	}
+
+	return tableFamilies[t.Family] + " " + t.Name
+}
+
+// l4protos - имена протоколов для meta l4proto.
+var l4protos = map[byte]string{
+	unix.IPPROTO_TCP:    "tcp",
+	unix.IPPROTO_UDP:    "udp",
+	unix.IPPROTO_ICMP:   "icmp",
+	unix.IPPROTO_ICMPV6: "ipv6-icmp",
+}
+
+// rejectNames - типы reject icmpx в синтаксисе nft.
+var rejectNames = map[uint8]string{
+	unix.NFT_REJECT_ICMPX_PORT_UNREACH:     "port-unreachable",
+	unix.NFT_REJECT_ICMPX_HOST_UNREACH:     "host-unreachable",
+	unix.NFT_REJECT_ICMPX_NO_ROUTE:         "no-route",
+	unix.NFT_REJECT_ICMPX_ADMIN_PROHIBITED: "admin-prohibited",
+}
+
+// formatRule возвращает выражения правила в синтаксисе nft.
+// Поддерживаются выражения, которые создает fwset: значение загружается в регистр и сравнивается или ищется в сете.
+func (r *Recorder) formatRule(rule *nftables.Rule) string {
+	var (
+		parts []string
+		load  string // выражение, загруженное в регистр
+		l4    bool   // загружен протокол, значение выводится по имени
+	)
+
+	for _, e := range rule.Exprs {
+		switch e := e.(type) {
+		case *expr.Meta:
+			l4 = e.Key == expr.MetaKeyL4PROTO
+			load = "meta nfproto"
+
+			if l4 {
+				load = "meta l4proto"
+			}
+		case *expr.Payload:
+			load = payloadName(e)
+		case *expr.Cmp:
+			parts = append(parts, load+" "+cmpValue(load, l4, e.Data))
+		case *expr.Lookup:
+			if elements, ok := r.anon[e.SetName]; ok {
+				parts = append(parts, load+" { "+formatElements(elements)+" }")
+			} else {
+				parts = append(parts, load+" @"+e.SetName)
+			}
+		case *expr.Counter:
+			parts = append(parts, "counter")
+		case *expr.Limit:
+			parts = append(parts, fmt.Sprintf("limit rate %d/%s", e.Rate, limitUnit(e.Unit)))
+		case *expr.Log:
+			parts = append(parts, formatLog(e))
+		case *expr.Reject:
+			if e.Type == unix.NFT_REJECT_TCP_RST {
+				parts = append(parts, "reject with tcp reset")
+			} else {
+				parts = append(parts, "reject with icmpx type "+rejectNames[e.Code])
+			}
+		case *expr.Verdict:
+			parts = append(parts, formatVerdict(e))
+		default:
+			parts = append(parts, fmt.Sprintf("%T", e))
+		}
+	}
+
+	if comment, ok := userdata.GetString(rule.UserData, userdata.TypeComment); ok {
+		parts = append(parts, "comment "+strconv.Quote(comment))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// payloadName возвращает имя поля заголовка, которое загружает выражение.
+func payloadName(e *expr.Payload) string {
+	if e.Base == expr.PayloadBaseTransportHeader && e.Offset == 2 && e.Len == 2 {
+		return "th dport"
+	}
+
+	for _, fam := range families {
+		if e.Base != expr.PayloadBaseNetworkHeader || e.Len != fam.len {
+			continue
+		}
+
+		proto := "ip"
+		if fam.len == net.IPv6len {
+			proto = "ip6"
+		}
+
+		switch e.Offset {
+		case fam.src:
+			return proto + " saddr"
+		case fam.dst:
+			return proto + " daddr"
+		}
+	}
+
+	return fmt.Sprintf("@%d,%d,%d", e.Base, e.Offset*8, e.Len*8)
+}
+
+// cmpValue возвращает значение сравнения в зависимости от загруженного поля.
+func cmpValue(load string, l4 bool, data []byte) string {
+	switch {
+	case l4 && len(data) == 1:
+		if name, ok := l4protos[data[0]]; ok {
+			return name
+		}
+	case load == "meta nfproto" && len(data) == 1:
+		switch data[0] {
+		case unix.NFPROTO_IPV4:
+			return "ipv4"
+		case unix.NFPROTO_IPV6:
+			return "ipv6"
+		}
+	case len(data) == 2:
+		return strconv.Itoa(int(binary.BigEndian.Uint16(data)))
+	}
+
+	if addr, ok := netip.AddrFromSlice(data); ok {
+		return addr.String()
+	}
+
+	return fmt.Sprintf("%#x", data)
+}
+
+func limitUnit(unit expr.LimitTime) string {
+	switch unit {
+	case expr.LimitTimeSecond:
+		return "second"
+	case expr.LimitTimeMinute:
+		return "minute"
+	case expr.LimitTimeHour:
+		return "hour"
+	case expr.LimitTimeDay:
+		return "day"
+	default:
+		return strconv.FormatUint(uint64(unit), 10)
+	}
+}
+
+func formatLog(e *expr.Log) string {
+	rv := "log"
+	if e.Key&(1<<unix.NFTA_LOG_PREFIX) != 0 {
+		rv += " prefix " + strconv.Quote(string(e.Data))
+	}
+
+	// уровень warn используется по умолчанию и nft его не выводит
+	if e.Key&(1<<unix.NFTA_LOG_LEVEL) != 0 && e.Level != expr.LogLevelWarning && int(e.Level) < len(config.LogLevels) {
+		rv += " level " + config.LogLevels[e.Level]
+	}
+
+	return rv
+}
+
+func formatVerdict(e *expr.Verdict) string {
+	switch e.Kind {
+	case expr.VerdictAccept:
+		return "accept"
+	case expr.VerdictDrop:
+		return "drop"
+	case expr.VerdictJump:
+		return "jump " + e.Chain
+	case expr.VerdictGoto:
+		return "goto " + e.Chain
+	case expr.VerdictReturn:
+		return "return"
+	default:
+		return fmt.Sprintf("verdict %d", e.Kind)
+	}
+}
+
+// formatElements возвращает элементы сета через запятую.
+// Интервал задается началом и следующим за концом элементом с IntervalEnd.
+// Завершающий пустой интервал, который fwset добавляет при создании сета, пропускается.
+func formatElements(elements []nftables.SetElement) string {
+	var (
+		parts []string
+		start *nftables.SetElement
+	)
+
+	for i := range elements {
+		elem := &elements[i]
+
+		if len(elem.Key) == 2 {
+			// порт
+			parts = append(parts, strconv.Itoa(int(binary.BigEndian.Uint16(elem.Key))))
+
+			continue
+		}
+
+		if !elem.IntervalEnd {
+			start = elem
+
+			continue
+		}
+
+		if start == nil {
+			continue
+		}
+
+		first, _ := netip.AddrFromSlice(start.Key)
+		last, _ := netip.AddrFromSlice(utils.PreviousIP(net.IP(elem.Key)))
+
+		part := utils.IPRange{Start: first, End: last}.String()
+		if start.Timeout != 0 {
+			part += " timeout " + start.Timeout.String()
+		}
+
+		if start.Comment != "" {
+			part += " comment " + strconv.Quote(start.Comment)
+		}
+
+		parts = append(parts, part)
+		start = nil
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,268 @@
+package fwset
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/utils"
+)
+
+// ErrNotInSet возвращается, если удаляемая сеть не пересекается ни с одним элементом сета.
+var ErrNotInSet = errors.New("network not in set")
+
+// ErrOverlap возвращается, если добавляемая сеть частично пересекается с элементом сета,
+// который нельзя с ней объединить (элемент или изменение с таймаутом или комментарием).
+var ErrOverlap = errors.New("network overlaps set element")
+
+// AddChange добавляет сети изменения с учетом содержимого сета.
+// Сети, которые уже есть в сете, пропускаются, пересекающиеся и смежные постоянные
+// элементы без комментария объединяются с новыми, поэтому сет остается минимальным,
+// а интервальные сеты nftables не отклоняют пересечения.
+// Если таймаут изменения больше оставшегося таймаута элемента, сеть добавляется заново
+// с новым таймаутом, а остальная часть элемента сохраняет прежний.
+// Пересечения с сетами с другим вердиктом логируются, а со Strict отменяют добавление.
+func (fw *Firewall) AddChange(change config.Change) error {
+	change.Add = true
+
+	changes, err := fw.reconcile(change)
+	if err == nil {
+		err = fw.checkConflicts(change)
+	}
+
+	if err != nil {
+		fw.recordChange(change, err)
+
+		return err
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return fw.Apply(changes)
+}
+
+// reconcile возвращает изменения, которые добавят сети change в сет с учетом его содержимого.
+func (fw *Firewall) reconcile(change config.Change) ([]config.Change, error) {
+	if _, err := utils.Aggregate(change.Networks); err != nil {
+		return nil, err
+	}
+
+	elements, err := fw.handler.ListElements(fw.config.ChangeSet(change))
+	if err != nil {
+		return nil, err
+	}
+
+	return reconcileElements(change, elements)
+}
+
+// reconcileElements возвращает изменения, которые добавят сети change в сет с элементами elements:
+// удаление поглощаемых элементов и добавление объединенных диапазонов.
+func reconcileElements(change config.Change, elements []config.Element) ([]config.Change, error) {
+	added, err := utils.Aggregate(change.Networks)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := change.Timeout == 0 && change.Comment == ""
+
+	var (
+		merge   []utils.IPRange // элементы, которые объединяются с новыми сетями
+		names   = make(map[utils.IPRange]string)
+		removes []string
+		keep    []config.Change // оставшиеся части элементов с продлеваемым таймаутом
+	)
+
+	for _, elem := range elements {
+		r, err := utils.ParseRange(elem.Network)
+		if err != nil {
+			return nil, err
+		}
+
+		if plain && elem.Expires == 0 && elem.Comment == "" {
+			merge = append(merge, r)
+			names[r] = elem.Network
+
+			continue
+		}
+
+		rest := make([]utils.IPRange, 0, len(added))
+
+		var refresh []utils.IPRange
+
+		for _, a := range added {
+			switch {
+			case !a.Overlaps(r):
+				rest = append(rest, a)
+			case r.Contains(a) && (elem.Expires == 0 || change.Timeout > 0 && change.Timeout <= elem.Expires):
+				// сеть уже есть в сете
+			case r.Contains(a) && change.Timeout > elem.Expires:
+				// таймаут продлевается: сеть добавляется заново
+				rest = append(rest, a)
+				refresh = append(refresh, a)
+			case a.Contains(r) && change.Timeout == 0:
+				// постоянная сеть поглощает элемент
+				rest = append(rest, a)
+				removes = append(removes, elem.Network)
+			default:
+				return nil, fmt.Errorf("%w: %s, %s", ErrOverlap, a, elem.Network)
+			}
+		}
+
+		if len(refresh) > 0 {
+			removes = append(removes, elem.Network)
+			keep = append(keep, cutElements(change, []config.Element{elem}, []utils.IPRange{r}, refresh)[1:]...)
+		}
+
+		added = rest
+	}
+
+	var adds []string
+
+	for _, c := range utils.CollapseRanges(append(merge, added...)) {
+		var inside []string
+
+		hasNew := false
+
+		for _, a := range added {
+			hasNew = hasNew || c.Contains(a)
+		}
+
+		for _, m := range merge {
+			if c.Contains(m) {
+				inside = append(inside, names[m])
+			}
+		}
+
+		if !hasNew || len(inside) == 1 && names[c] == inside[0] {
+			// диапазон не изменился
+			continue
+		}
+
+		removes = append(removes, inside...)
+		adds = append(adds, c.String())
+	}
+
+	var rv []config.Change
+
+	if len(removes) > 0 {
+		rv = append(rv, config.Change{Set: change.Set, Accept: change.Accept, Networks: removes})
+	}
+
+	rv = append(rv, keep...)
+
+	if len(adds) > 0 {
+		change.Networks = adds
+		rv = append(rv, change)
+	}
+
+	return rv, nil
+}
+
+// reconcileRemove возвращает изменения, которые удалят сети change из сета с учетом его содержимого.
+func (fw *Firewall) reconcileRemove(change config.Change) ([]config.Change, error) {
+	if _, err := utils.Aggregate(change.Networks); err != nil {
+		return nil, err
+	}
+
+	elements, err := fw.handler.ListElements(fw.config.ChangeSet(change))
+	if err != nil {
+		return nil, err
+	}
+
+	return removeElements(change, elements)
+}
+
+// removeElements возвращает изменения, которые удалят сети change из сета с элементами elements.
+// Элементы, которые покрывают удаляемые сети частично (например, объединенные при добавлении),
+// удаляются, а их оставшиеся части добавляются заново с прежними таймаутом и комментарием.
+func removeElements(change config.Change, elements []config.Element) ([]config.Change, error) {
+	removed, err := utils.Aggregate(change.Networks)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges, err := elementRanges(elements)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range removed {
+		if !slices.ContainsFunc(ranges, r.Overlaps) {
+			return nil, fmt.Errorf("%w: %s", ErrNotInSet, r)
+		}
+	}
+
+	return cutElements(change, elements, ranges, removed), nil
+}
+
+// cutElements возвращает изменения, которые удалят адреса cut из элементов сета:
+// удаление пересекающихся с cut элементов и добавление их оставшихся частей.
+// ranges - разобранные диапазоны elements.
+func cutElements(change config.Change, elements []config.Element, ranges, cut []utils.IPRange) []config.Change {
+	type attrs struct {
+		timeout time.Duration
+		comment string
+	}
+
+	var (
+		removes []string
+		order   []attrs // порядок добавлений для воспроизводимости
+		rest    = make(map[attrs][]string)
+	)
+
+	for i, elem := range elements {
+		if !slices.ContainsFunc(cut, ranges[i].Overlaps) {
+			continue
+		}
+
+		removes = append(removes, elem.Network)
+
+		key := attrs{elem.Expires, elem.Comment}
+
+		for _, r := range utils.Subtract(ranges[i:i+1], cut) {
+			if _, ok := rest[key]; !ok {
+				order = append(order, key)
+			}
+
+			rest[key] = append(rest[key], r.String())
+		}
+	}
+
+	if len(removes) == 0 {
+		return nil
+	}
+
+	rv := []config.Change{{Set: change.Set, Accept: change.Accept, Networks: removes}}
+
+	for _, key := range order {
+		rv = append(rv, config.Change{
+			Set:      change.Set,
+			Accept:   change.Accept,
+			Add:      true,
+			Networks: rest[key],
+			Timeout:  key.timeout,
+			Comment:  key.comment,
+		})
+	}
+
+	return rv
+}
+
+// applyElements возвращает элементы сета после изменения, которое вернули reconcileElements или removeElements.
+func applyElements(elements []config.Element, change config.Change) []config.Element {
+	if !change.Add {
+		return slices.DeleteFunc(slices.Clone(elements), func(elem config.Element) bool {
+			return slices.Contains(change.Networks, elem.Network)
+		})
+	}
+
+	for _, network := range change.Networks {
+		elements = append(elements, config.Element{Network: network, Expires: change.Timeout, Comment: change.Comment})
+	}
+
+	return elements
+}
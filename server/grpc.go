@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/LeKovr/fwset"
+	"github.com/LeKovr/fwset/api"
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/utils"
+)
+
+// GRPCService реализует gRPC API поверх Hub.
+type GRPCService struct {
+	api.UnimplementedFWSetServer
+
+	hub *Hub
+}
+
+// NewGRPC возвращает gRPC сервер с зарегистрированным сервисом.
+// Если задан token, запросы должны содержать заголовок "authorization: Bearer <token>".
+// Сведения для журнала аудита передаются в метаданных запроса (см. HeaderActor).
+func NewGRPC(hub *Hub, token string) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.Creds(newPeerCreds())}
+	if token != "" {
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if err := checkToken(ctx, token); err != nil {
+					return nil, err
+				}
+
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := checkToken(ss.Context(), token); err != nil {
+					return err
+				}
+
+				return handler(srv, ss)
+			}),
+		)
+	}
+
+	srv := grpc.NewServer(opts...)
+	api.RegisterFWSetServer(srv, &GRPCService{hub: hub})
+
+	return srv
+}
+
+func (srv *GRPCService) CreateSets(ctx context.Context, _ *api.CreateSetsRequest) (*api.CreateSetsResponse, error) {
+	if err := srv.hub.Create(grpcOrigin(ctx)); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &api.CreateSetsResponse{}, nil
+}
+
+func (srv *GRPCService) DestroySets(ctx context.Context, _ *api.DestroySetsRequest) (*api.DestroySetsResponse, error) {
+	if err := srv.hub.Destroy(grpcOrigin(ctx)); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &api.DestroySetsResponse{}, nil
+}
+
+func (srv *GRPCService) AddNetworks(ctx context.Context, req *api.AddNetworksRequest) (*api.AddNetworksResponse, error) {
+	change := config.Change{
+		Set:      req.GetSet(),
+		Accept:   req.GetAccept(),
+		Add:      true,
+		Networks: req.GetNetworks(),
+		Comment:  req.GetComment(),
+	}
+	if req.GetTimeout() != nil {
+		change.Timeout = req.GetTimeout().AsDuration()
+	}
+
+	if err := srv.apply(ctx, change); err != nil {
+		return nil, err
+	}
+
+	return &api.AddNetworksResponse{}, nil
+}
+
+func (srv *GRPCService) RemoveNetworks(ctx context.Context, req *api.RemoveNetworksRequest) (*api.RemoveNetworksResponse, error) {
+	if err := srv.apply(ctx, config.Change{Set: req.GetSet(), Accept: req.GetAccept(), Networks: req.GetNetworks()}); err != nil {
+		return nil, err
+	}
+
+	return &api.RemoveNetworksResponse{}, nil
+}
+
+func (srv *GRPCService) ListNetworks(context.Context, *api.ListNetworksRequest) (*api.ListNetworksResponse, error) {
+	sets, err := srv.hub.Sets()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &api.ListNetworksResponse{Sets: make([]*api.Set, len(sets))}
+
+	for i, set := range sets {
+		rv := &api.Set{
+			Name:     set.Name,
+			Verdict:  set.Verdict,
+			Backend:  set.Backend,
+			Table:    set.Table,
+			Elements: make([]*api.Element, len(set.Elements)),
+		}
+
+		for j, elem := range set.Elements {
+			rv.Elements[j] = &api.Element{Network: elem.Network, Type: elem.Type, Comment: elem.Comment}
+			if expires, err := time.ParseDuration(elem.Expires); err == nil {
+				rv.Elements[j].Expires = durationpb.New(expires)
+			}
+		}
+
+		resp.Sets[i] = rv
+	}
+
+	return resp, nil
+}
+
+func (srv *GRPCService) WatchChanges(_ *api.WatchChangesRequest, stream grpc.ServerStreamingServer[api.Change]) error {
+	events, cancel := srv.hub.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// apply проверяет сети и выполняет изменение.
+func (srv *GRPCService) apply(ctx context.Context, change config.Change) error {
+	if len(change.Networks) == 0 {
+		return status.Error(codes.InvalidArgument, ErrNoNetworks.Error())
+	}
+
+	for _, network := range change.Networks {
+		if _, err := utils.ParseRange(network); err != nil {
+			return status.Errorf(codes.InvalidArgument, "%s: %v", network, err)
+		}
+	}
+
+	if err := srv.hub.Change(grpcOrigin(ctx), change); err != nil {
+		code := codes.Internal
+		if errors.Is(err, fwset.ErrConflict) || errors.Is(err, fwset.ErrOverlap) {
+			code = codes.FailedPrecondition
+		}
+
+		return status.Error(code, err.Error())
+	}
+
+	return nil
+}
+
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, value := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/LeKovr/fwset"
+	"github.com/LeKovr/fwset/api"
+	"github.com/LeKovr/fwset/audit"
+	"github.com/LeKovr/fwset/config"
+)
+
+// subscriberBuffer - размер очереди событий подписчика.
+const subscriberBuffer = 64
+
+// Hub выполняет обращения к фаерволу последовательно
+// и рассылает подписчикам выполненные изменения.
+// Изменения пишутся в журнал аудита от имени клиента API (origin запроса).
+type Hub struct {
+	fw   Firewall
+	mu   sync.Mutex
+	subs map[chan *api.Change]struct{}
+	smu  sync.Mutex
+}
+
+// NewHub возвращает экземпляр Hub.
+func NewHub(fw Firewall) *Hub {
+	return &Hub{fw: fw, subs: make(map[chan *api.Change]struct{})}
+}
+
+func (h *Hub) Create(origin audit.Origin) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.as(origin)()
+
+	if err := h.fw.Create(); err != nil {
+		return err
+	}
+
+	h.publish(&api.Change{Action: api.Change_ACTION_CREATE})
+
+	return nil
+}
+
+func (h *Hub) Destroy(origin audit.Origin) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.as(origin)()
+
+	if err := h.fw.Destroy(); err != nil {
+		return err
+	}
+
+	h.publish(&api.Change{Action: api.Change_ACTION_DESTROY})
+
+	return nil
+}
+
+// Change добавляет или удаляет сети изменения с учетом содержимого сета (AddChange, RemoveChange),
+// как команды add и del.
+func (h *Hub) Change(origin audit.Origin, change config.Change) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.as(origin)()
+
+	event := &api.Change{
+		Action:   api.Change_ACTION_REMOVE,
+		Set:      change.Set,
+		Accept:   change.Accept,
+		Networks: change.Networks,
+		Comment:  change.Comment,
+	}
+
+	var err error
+
+	if change.Add {
+		event.Action = api.Change_ACTION_ADD
+		err = h.fw.AddChange(change)
+	} else {
+		err = h.fw.RemoveChange(change)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if change.Timeout > 0 {
+		event.Timeout = durationpb.New(change.Timeout)
+	}
+
+	h.publish(event)
+
+	return nil
+}
+
+func (h *Hub) Sets() ([]fwset.Set, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.fw.Sets()
+}
+
+// as включает запись журнала от имени клиента API и возвращает функцию,
+// которая восстанавливает прежний origin. Вызывается под mu.
+func (h *Hub) as(origin audit.Origin) func() {
+	base := h.fw.Origin()
+	h.fw.SetOrigin(base.With(origin))
+
+	return func() { h.fw.SetOrigin(base) }
+}
+
+// Subscribe возвращает канал изменений и функцию отказа от подписки.
+func (h *Hub) Subscribe() (<-chan *api.Change, func()) {
+	ch := make(chan *api.Change, subscriberBuffer)
+
+	h.smu.Lock()
+	h.subs[ch] = struct{}{}
+	h.smu.Unlock()
+
+	return ch, func() {
+		h.smu.Lock()
+		delete(h.subs, ch)
+		h.smu.Unlock()
+	}
+}
+
+// publish рассылает изменение подписчикам. Если очередь подписчика заполнена, событие для него теряется.
+func (h *Hub) publish(event *api.Change) {
+	event.Time = timestamppb.New(time.Now())
+
+	h.smu.Lock()
+	defer h.smu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("Subscriber queue is full, change dropped", "action", event.Action)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os/user"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/LeKovr/fwset/audit"
+)
+
+// Заголовки HTTP запроса со сведениями для журнала аудита.
+// В gRPC те же сведения передаются в метаданных x-fwset-actor, x-fwset-reason и x-fwset-ticket.
+const (
+	HeaderActor  = "X-Fwset-Actor"
+	HeaderReason = "X-Fwset-Reason"
+	HeaderTicket = "X-Fwset-Ticket"
+)
+
+// credKey - ключ контекста соединения с учетными данными клиента unix сокета.
+type credKey struct{}
+
+// connContext сохраняет в контексте HTTP соединения учетные данные клиента unix сокета.
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	if cred := peerCred(conn); cred != nil {
+		return context.WithValue(ctx, credKey{}, cred)
+	}
+
+	return ctx
+}
+
+// peerCred возвращает учетные данные процесса клиента unix сокета (SO_PEERCRED), для TCP - nil.
+func peerCred(conn net.Conn) *unix.Ucred {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var cred *unix.Ucred
+
+	_ = raw.Control(func(fd uintptr) {
+		cred, _ = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+
+	return cred
+}
+
+// peerOrigin возвращает сведения о клиенте API для журнала аудита.
+// Для клиента unix сокета вместо адреса сохраняются процесс и его владелец.
+func peerOrigin(actor, reason, ticket string, addr string, cred *unix.Ucred) audit.Origin {
+	rv := audit.Origin{Actor: actor, Reason: reason, Ticket: ticket}
+
+	if cred == nil {
+		rv.Peer = addr
+
+		return rv
+	}
+
+	rv.PeerPID = int(cred.Pid)
+	rv.PeerUser = strconv.Itoa(int(cred.Uid))
+
+	if u, err := user.LookupId(rv.PeerUser); err == nil {
+		rv.PeerUser = u.Username
+	}
+
+	return rv
+}
+
+// httpOrigin возвращает сведения о клиенте HTTP API.
+func httpOrigin(r *http.Request) audit.Origin {
+	cred, _ := r.Context().Value(credKey{}).(*unix.Ucred)
+
+	return peerOrigin(r.Header.Get(HeaderActor), r.Header.Get(HeaderReason), r.Header.Get(HeaderTicket), r.RemoteAddr, cred)
+}
+
+// grpcOrigin возвращает сведения о клиенте gRPC API.
+func grpcOrigin(ctx context.Context) audit.Origin {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+
+		return ""
+	}
+
+	var (
+		addr string
+		cred *unix.Ucred
+	)
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			addr = p.Addr.String()
+		}
+
+		if info, ok := p.AuthInfo.(credInfo); ok {
+			cred = info.cred
+		}
+	}
+
+	return peerOrigin(first(HeaderActor), first(HeaderReason), first(HeaderTicket), addr, cred)
+}
+
+// peerCreds - транспорт gRPC без шифрования, который передает в AuthInfo
+// учетные данные клиента unix сокета.
+type peerCreds struct {
+	credentials.TransportCredentials
+}
+
+func newPeerCreds() peerCreds {
+	return peerCreds{insecure.NewCredentials()}
+}
+
+func (c peerCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, credInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity},
+		cred:           peerCred(conn),
+	}, nil
+}
+
+func (c peerCreds) Clone() credentials.TransportCredentials {
+	return newPeerCreds()
+}
+
+// credInfo - AuthInfo соединения gRPC с учетными данными клиента unix сокета.
+type credInfo struct {
+	credentials.CommonAuthInfo
+
+	cred *unix.Ucred
+}
+
+func (credInfo) AuthType() string {
+	return "insecure"
+}
@@ -0,0 +1,330 @@
+// Package server holds HTTP+JSON and gRPC API for firewall set management.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/LeKovr/fwset"
+	"github.com/LeKovr/fwset/audit"
+	"github.com/LeKovr/fwset/config"
+)
+
+// Config holds server settings.
+type Config struct {
+	Listen     string `default:"/run/fwset.sock"                                                           description:"Listen address: unix socket path or host:port" env:"LISTEN"       long:"listen"`
+	Token      string `description:"Bearer token (required for TCP)"                                       env:"TOKEN"                                                 long:"token"`
+	GRPCListen string `description:"gRPC listen address: unix socket path or host:port, disabled if empty" env:"GRPC_LISTEN"                                           long:"grpc_listen"`
+}
+
+// Firewall описывает используемые сервером методы fwset.Firewall.
+type Firewall interface {
+	Create() error
+	Destroy() error
+	AddChange(change config.Change) error
+	RemoveChange(change config.Change) error
+	Sets() ([]fwset.Set, error)
+	Origin() audit.Origin
+	SetOrigin(origin audit.Origin)
+}
+
+// NetworksRequest - тело запроса на добавление или удаление сетей.
+type NetworksRequest struct {
+	Set      string   `json:"set,omitempty"` // если не задан, используется сет accept или drop
+	Accept   bool     `json:"accept"`
+	Networks []string `json:"networks"`
+	Timeout  string   `json:"timeout,omitempty"` // например, "15m", только для добавления
+	Comment  string   `json:"comment,omitempty"` // только для добавления
+}
+
+// Response - тело ответа.
+type Response struct {
+	Status string      `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Sets   []fwset.Set `json:"sets,omitempty"`
+}
+
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+
+	shutdownTimeout = 5 * time.Second
+	maxBodySize     = 4 << 20 // размер тела запроса, около 200 тысяч сетей
+)
+
+var (
+	ErrNoNetworks = errors.New("networks required")
+	ErrNoToken    = errors.New("token required for TCP listener")
+	ErrNotSocket  = errors.New("file exists and is not a socket")
+)
+
+// Service обрабатывает запросы API. Обращения к фаерволу выполняются последовательно через Hub.
+// Причина, тикет и пользователь клиента для журнала аудита передаются в заголовках
+// HeaderReason, HeaderTicket и HeaderActor.
+type Service struct {
+	hub   *Hub
+	token string
+}
+
+// New возвращает экземпляр сервиса.
+func New(hub *Hub, token string) *Service {
+	return &Service{hub: hub, token: token}
+}
+
+// Handler возвращает обработчик HTTP запросов.
+func (srv *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sets", srv.handleList)
+	mux.HandleFunc("POST /sets", srv.handleCreate)
+	mux.HandleFunc("DELETE /sets", srv.handleDestroy)
+	mux.HandleFunc("POST /networks", srv.handleNetworks(true))
+	mux.HandleFunc("DELETE /networks", srv.handleNetworks(false))
+
+	if srv.token == "" {
+		return mux
+	}
+
+	return srv.auth(mux)
+}
+
+// Run обслуживает запросы до отмены ctx.
+// Если задан GRPCListen, параллельно обслуживается gRPC API.
+func Run(ctx context.Context, cfg Config, fw Firewall) error {
+	listener, err := listen(ctx, cfg.Listen, cfg.Token)
+	if err != nil {
+		return err
+	}
+
+	hub := NewHub(fw)
+
+	httpServer := &http.Server{
+		Handler:           New(hub, cfg.Token).Handler(),
+		ReadHeaderTimeout: shutdownTimeout,
+		ConnContext:       connContext,
+	}
+
+	var grpcServer *grpc.Server
+
+	errs := make(chan error, 1)    // ошибка gRPC сервера
+	grpcErr := make(chan error, 1) // ошибка, из-за которой остановлен HTTP сервер
+
+	if cfg.GRPCListen != "" {
+		var grpcListener net.Listener
+
+		if grpcListener, err = listen(ctx, cfg.GRPCListen, cfg.Token); err != nil {
+			listener.Close()
+
+			return err
+		}
+
+		grpcServer = NewGRPC(hub, cfg.Token)
+
+		go func() { errs <- grpcServer.Serve(grpcListener) }()
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case err := <-errs:
+			grpcErr <- err
+		}
+
+		if grpcServer != nil {
+			grpcServer.Stop() // WatchChanges завершается только по отмене запроса
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := httpServer.Shutdown(shutdownCtx); err != nil { //nolint:contextcheck // ctx уже отменен
+			slog.Error("Shutdown", "err", err)
+		}
+	}()
+
+	if err = httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	select {
+	case err = <-grpcErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// removeSocket удаляет сокет, который мог остаться после аварийного завершения.
+// Файл другого типа не удаляется, чтобы опечатка в адресе не стерла чужой файл.
+func removeSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%w: %s", ErrNotSocket, path)
+	}
+
+	return os.Remove(path)
+}
+
+// listen открывает TCP порт, если адрес имеет вид host:port, иначе unix сокет.
+// Путь, который начинается с "/" или ".", всегда считается сокетом.
+func listen(ctx context.Context, addr, token string) (net.Listener, error) {
+	network := "unix"
+	if _, _, err := net.SplitHostPort(addr); err == nil && !strings.HasPrefix(addr, "/") && !strings.HasPrefix(addr, ".") {
+		network = "tcp"
+
+		if token == "" {
+			return nil, ErrNoToken
+		}
+	}
+
+	if network == "unix" {
+		if err := removeSocket(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	lc := net.ListenConfig{}
+
+	listener, err := lc.Listen(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		if err = os.Chmod(addr, 0o660); err != nil {
+			listener.Close()
+
+			return nil, err
+		}
+	}
+
+	slog.Info("Listen", "network", network, "addr", addr)
+
+	return listener, nil
+}
+
+func (srv *Service) auth(next http.Handler) http.Handler {
+	want := []byte("Bearer " + srv.token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeJSON(w, http.StatusUnauthorized, Response{Status: StatusError, Error: "unauthorized"})
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (srv *Service) handleList(w http.ResponseWriter, _ *http.Request) {
+	sets, err := srv.hub.Sets()
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{Status: StatusOK, Sets: sets})
+}
+
+func (srv *Service) handleCreate(w http.ResponseWriter, r *http.Request) {
+	srv.call(w, func() error { return srv.hub.Create(httpOrigin(r)) })
+}
+
+func (srv *Service) handleDestroy(w http.ResponseWriter, r *http.Request) {
+	srv.call(w, func() error { return srv.hub.Destroy(httpOrigin(r)) })
+}
+
+func (srv *Service) handleNetworks(add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req NetworksRequest
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+			code := http.StatusBadRequest
+
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				code = http.StatusRequestEntityTooLarge
+			}
+
+			writeError(w, code, err)
+
+			return
+		}
+
+		if len(req.Networks) == 0 {
+			writeError(w, http.StatusBadRequest, ErrNoNetworks)
+
+			return
+		}
+
+		change := config.Change{Set: req.Set, Accept: req.Accept, Add: add, Networks: req.Networks}
+
+		if req.Timeout != "" && add {
+			timeout, err := time.ParseDuration(req.Timeout)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+
+				return
+			}
+
+			change.Timeout = timeout
+		}
+
+		if add {
+			change.Comment = req.Comment
+		}
+
+		srv.call(w, func() error { return srv.hub.Change(httpOrigin(r), change) })
+	}
+}
+
+// call выполняет операцию с фаерволом и пишет ответ.
+// Отказ из-за пересечения с сетом с другим вердиктом (Strict) возвращается как 409.
+func (srv *Service) call(w http.ResponseWriter, fn func() error) {
+	if err := fn(); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, fwset.ErrConflict) || errors.Is(err, fwset.ErrOverlap) {
+			code = http.StatusConflict
+		}
+
+		writeError(w, code, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{Status: StatusOK})
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, Response{Status: StatusError, Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("Write response", "err", err)
+	}
+}
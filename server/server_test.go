@@ -0,0 +1,371 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/LeKovr/fwset"
+	"github.com/LeKovr/fwset/api"
+	"github.com/LeKovr/fwset/audit"
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/utils"
+)
+
+type FakeFirewall struct {
+	Created bool
+	Changes []config.Change
+	Origins []audit.Origin // origin каждого изменения
+	Err     error
+
+	origin audit.Origin
+}
+
+func (f *FakeFirewall) Create() error {
+	f.Created = true
+	return f.Err
+}
+
+func (f *FakeFirewall) Destroy() error {
+	f.Created = false
+	return f.Err
+}
+
+func (f *FakeFirewall) AddChange(change config.Change) error {
+	f.Changes = append(f.Changes, change)
+	f.Origins = append(f.Origins, f.origin)
+	return f.Err
+}
+
+func (f *FakeFirewall) RemoveChange(change config.Change) error {
+	return f.AddChange(change)
+}
+
+func (f *FakeFirewall) Origin() audit.Origin {
+	return f.origin
+}
+
+func (f *FakeFirewall) SetOrigin(origin audit.Origin) {
+	f.origin = origin
+}
+
+func (f *FakeFirewall) Sets() ([]fwset.Set, error) {
+	return []fwset.Set{{Name: "blocked_nets", Verdict: fwset.VerdictDrop}}, f.Err
+}
+
+// FakeTables хранит элементы сетов в памяти и, как интервальные сеты nftables,
+// отклоняет пересекающиеся элементы и удаление отсутствующих.
+type FakeTables struct {
+	Config   config.Config
+	Elements map[string][]config.Element
+}
+
+func (f *FakeTables) Create(config.SetSpec) error { return nil }
+func (f *FakeTables) Destroy() error              { return nil }
+
+func (f *FakeTables) Modify(set string, add bool, networks []string) error {
+	return f.Apply([]config.Change{{Set: set, Add: add, Networks: networks}})
+}
+
+func (f *FakeTables) Add(set string, networks []string) error {
+	return f.Modify(set, true, networks)
+}
+
+func (f *FakeTables) Remove(set string, networks []string) error {
+	return f.Modify(set, false, networks)
+}
+
+func (f *FakeTables) List(set string) ([]string, error) {
+	var rv []string
+	for _, elem := range f.Elements[set] {
+		rv = append(rv, elem.Network)
+	}
+	return rv, nil
+}
+
+func (f *FakeTables) ListElements(set string) ([]config.Element, error) {
+	return f.Elements[set], nil
+}
+
+func (f *FakeTables) Replace(change config.Change) error {
+	delete(f.Elements, f.Config.ChangeSet(change))
+	return f.Apply([]config.Change{change})
+}
+
+func (f *FakeTables) Apply(changes []config.Change) error {
+	elements := make(map[string][]config.Element, len(f.Elements))
+	for set, elems := range f.Elements {
+		elements[set] = slices.Clone(elems)
+	}
+
+	for _, change := range changes {
+		set := f.Config.ChangeSet(change)
+
+		for _, network := range change.Networks {
+			r, err := utils.ParseRange(network)
+			if err != nil {
+				return err
+			}
+
+			i := slices.IndexFunc(elements[set], func(elem config.Element) bool {
+				er, _ := utils.ParseRange(elem.Network)
+				return er.Overlaps(r)
+			})
+
+			switch {
+			case change.Add && i >= 0:
+				return fmt.Errorf("%s: %w", network, os.ErrExist) // interval overlaps
+			case change.Add:
+				elements[set] = append(elements[set], config.Element{Network: network, Expires: change.Timeout, Comment: change.Comment})
+			case i < 0 || elements[set][i].Network != network:
+				return fmt.Errorf("%s: %w", network, os.ErrNotExist)
+			default:
+				elements[set] = slices.Delete(elements[set], i, i+1)
+			}
+		}
+	}
+
+	f.Elements = elements
+
+	return nil
+}
+
+func TestReconcileAPI(t *testing.T) {
+	cfg := fwset.Config{FW: fwset.FWNameNFTables, Config: config.Config{SetNameAccept: "allowed_nets", SetNameDrop: "blocked_nets"}}
+	tables := &FakeTables{Config: cfg.Config, Elements: map[string][]config.Element{}}
+	handler := New(NewHub(fwset.NewFirewall(cfg, tables)), "").Handler()
+
+	send := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/networks", strings.NewReader(body)))
+		return rec
+	}
+
+	// сеть поглощает добавленный ранее адрес
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, `{"networks":["10.1.2.3"]}`).Code)
+	rec := send(http.MethodPost, `{"networks":["10.0.0.0/8"]}`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []config.Element{{Network: "10.0.0.0/8"}}, tables.Elements["blocked_nets"])
+
+	// удаление части объединенного диапазона
+	rec = send(http.MethodDelete, `{"networks":["10.128.0.0/9"]}`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []config.Element{{Network: "10.0.0.0/9"}}, tables.Elements["blocked_nets"])
+}
+
+func TestStrictAPI(t *testing.T) {
+	cfg := fwset.Config{FW: fwset.FWNameNFTables, Strict: true, Config: config.Config{SetNameAccept: "allowed_nets", SetNameDrop: "blocked_nets"}}
+	tables := &FakeTables{Config: cfg.Config, Elements: map[string][]config.Element{"allowed_nets": {{Network: "10.0.0.5"}}}}
+	hub := NewHub(fwset.NewFirewall(cfg, tables))
+
+	rec := httptest.NewRecorder()
+	New(hub, "").Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/networks", strings.NewReader(`{"networks":["10.0.0.0/24"]}`)))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), fwset.ErrConflict.Error())
+
+	_, err := (&GRPCService{hub: hub}).AddNetworks(context.Background(), &api.AddNetworksRequest{Networks: []string{"10.0.0.0/24"}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Empty(t, tables.Elements["blocked_nets"])
+}
+
+func TestHandler(t *testing.T) {
+	fw := &FakeFirewall{}
+	handler := New(NewHub(fw), "").Handler()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{"Create", http.MethodPost, "/sets", "", http.StatusOK, `{"status":"ok"}`},
+		{"List", http.MethodGet, "/sets", "", http.StatusOK, `"name":"blocked_nets"`},
+		{"Add", http.MethodPost, "/networks", `{"networks":["10.0.0.1"],"timeout":"15m","comment":"abuse"}`, http.StatusOK, `{"status":"ok"}`},
+		{"Remove", http.MethodDelete, "/networks", `{"accept":true,"networks":["10.0.0.0/24"]}`, http.StatusOK, `{"status":"ok"}`},
+		{"AddToSet", http.MethodPost, "/networks", `{"set":"scanners","networks":["192.0.2.1"]}`, http.StatusOK, `{"status":"ok"}`},
+		{"NoNetworks", http.MethodPost, "/networks", `{}`, http.StatusBadRequest, ErrNoNetworks.Error()},
+		{"BadTimeout", http.MethodPost, "/networks", `{"networks":["10.0.0.1"],"timeout":"soon"}`, http.StatusBadRequest, "invalid duration"},
+		{"BadJSON", http.MethodPost, "/networks", `[`, http.StatusBadRequest, "error"},
+		{"TooLarge", http.MethodPost, "/networks", `{"networks":["` + strings.Repeat("1", maxBodySize) + `"]}`, http.StatusRequestEntityTooLarge, "too large"},
+		{"Destroy", http.MethodDelete, "/sets", "", http.StatusOK, `{"status":"ok"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.code, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.want)
+		})
+	}
+
+	assert.False(t, fw.Created)
+	assert.Equal(t, []config.Change{
+		{Add: true, Networks: []string{"10.0.0.1"}, Timeout: 15 * time.Minute, Comment: "abuse"},
+		{Accept: true, Networks: []string{"10.0.0.0/24"}},
+		{Set: "scanners", Add: true, Networks: []string{"192.0.2.1"}},
+	}, fw.Changes)
+	assert.Equal(t, "192.0.2.1:1234", fw.Origins[0].Peer, "TCP client address")
+
+	fw.Err = errors.New("netlink error")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sets", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "netlink error")
+}
+
+func TestAuth(t *testing.T) {
+	handler := New(NewHub(&FakeFirewall{}), "secret").Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sets", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/sets", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRun(t *testing.T) {
+	err := Run(context.Background(), Config{Listen: "127.0.0.1:0"}, &FakeFirewall{})
+	assert.ErrorIs(t, err, ErrNoToken)
+
+	socket := filepath.Join(t.TempDir(), "fwset.sock")
+	grpcSocket := filepath.Join(t.TempDir(), "fwset-grpc.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	fw := &FakeFirewall{origin: audit.Origin{Command: "serve"}}
+
+	go func() { done <- Run(ctx, Config{Listen: socket, GRPCListen: grpcSocket}, fw) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("http://fwset/sets")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	conn, err := grpc.NewClient("unix://"+grpcSocket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	resp, err := api.NewFWSetClient(conn).ListNetworks(ctx, &api.ListNetworksRequest{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "blocked_nets", resp.GetSets()[0].GetName())
+	}
+
+	// сведения для журнала аудита: заголовки запроса и учетные данные клиента unix сокета
+	req, _ := http.NewRequest(http.MethodPost, "http://fwset/networks", strings.NewReader(`{"networks":["10.0.0.1"]}`))
+	req.Header.Set(HeaderActor, "alice")
+	req.Header.Set(HeaderReason, "scan")
+
+	if httpResp, err := client.Do(req); assert.NoError(t, err) {
+		httpResp.Body.Close()
+	}
+
+	mdCtx := metadata.AppendToOutgoingContext(ctx, "x-fwset-actor", "bob", "x-fwset-ticket", "SEC-1")
+	_, err = api.NewFWSetClient(conn).AddNetworks(mdCtx, &api.AddNetworksRequest{Networks: []string{"10.0.0.2"}})
+	assert.NoError(t, err)
+
+	self := peerOrigin("", "", "", "", &unix.Ucred{Pid: int32(os.Getpid()), Uid: uint32(os.Getuid())})
+	assert.Equal(t, []audit.Origin{
+		{Command: "serve", Actor: "alice", Reason: "scan", PeerPID: self.PeerPID, PeerUser: self.PeerUser},
+		{Command: "serve", Actor: "bob", Ticket: "SEC-1", PeerPID: self.PeerPID, PeerUser: self.PeerUser},
+	}, fw.Origins)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestListen(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// относительный путь без "./" - unix сокет
+	listener, err := listen(context.Background(), "fwset.sock", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "unix", listener.Addr().Network())
+		listener.Close()
+	}
+
+	// сокет, оставшийся после аварийного завершения, заменяется
+	stale, err := net.Listen("unix", "stale.sock")
+	if assert.NoError(t, err) {
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+	}
+
+	listener, err = listen(context.Background(), "stale.sock", "")
+	if assert.NoError(t, err) {
+		listener.Close()
+	}
+
+	// другие файлы не удаляются
+	assert.NoError(t, os.WriteFile("fwset.conf", []byte("keep"), 0o600))
+	_, err = listen(context.Background(), "fwset.conf", "")
+	assert.ErrorIs(t, err, ErrNotSocket)
+	assert.FileExists(t, "fwset.conf")
+
+	_, err = listen(context.Background(), "localhost:0", "")
+	assert.ErrorIs(t, err, ErrNoToken)
+
+	listener, err = listen(context.Background(), "127.0.0.1:0", "secret")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "tcp", listener.Addr().Network())
+		listener.Close()
+	}
+}
+
+func TestHub(t *testing.T) {
+	fw := &FakeFirewall{origin: audit.Origin{Command: "serve"}}
+	hub := NewHub(fw)
+
+	events, cancel := hub.Subscribe()
+	assert.NoError(t, hub.Change(audit.Origin{Actor: "alice"}, config.Change{Add: true, Networks: []string{"10.0.0.1"}}))
+
+	assert.Equal(t, []audit.Origin{{Command: "serve", Actor: "alice"}}, fw.Origins)
+	assert.Equal(t, audit.Origin{Command: "serve"}, fw.Origin(), "origin is restored")
+
+	event := <-events
+	assert.Equal(t, api.Change_ACTION_ADD, event.GetAction())
+	assert.Equal(t, []string{"10.0.0.1"}, event.GetNetworks())
+
+	fw.Err = errors.New("netlink error")
+	assert.Error(t, hub.Create(audit.Origin{}))
+	assert.Empty(t, events, "failed calls are not published")
+
+	cancel()
+	fw.Err = nil
+	assert.NoError(t, hub.Destroy(audit.Origin{}))
+	assert.Empty(t, events)
+}
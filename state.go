@@ -0,0 +1,313 @@
+package fwset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/utils"
+)
+
+// State описывает желаемое содержимое сетов.
+// Сети можно задать списками accept/drop для сетов по умолчанию или в формате экспорта (sets).
+type State struct {
+	Accept []string `json:"accept,omitempty" yaml:"accept,omitempty"`
+	Drop   []string `json:"drop,omitempty"   yaml:"drop,omitempty"`
+	Sets   []Set    `json:"sets,omitempty"   yaml:"sets,omitempty"`
+}
+
+// ErrEmptyState возвращается для пустого файла состояния, который иначе очистил бы все сеты.
+var ErrEmptyState = errors.New("state is empty")
+
+// ReadState читает желаемое состояние в формате YAML (или JSON).
+// Неизвестные ключи, например, с опечаткой, и пустой ввод - ошибка, чтобы не очистить сеты по ошибке.
+// Для очистки сетов состояние задается явно, например, `accept: []`.
+func ReadState(r io.Reader) (*State, error) {
+	var state State
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	if err := dec.Decode(&state); err != nil {
+		if err == io.EOF {
+			return nil, ErrEmptyState
+		}
+
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// LoadSets читает описания дополнительных сетов из файла YAML.
+func LoadSets(filename string) ([]config.SetSpec, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var specs []config.SetSpec
+
+	if err = yaml.NewDecoder(f).Decode(&specs); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return specs, nil
+}
+
+// elements возвращает желаемые элементы сетов по именам.
+// Сеты без имени в формате экспорта относятся к сету accept или drop по вердикту.
+func (state State) elements(cfg config.Config) map[string][]Element {
+	rv := map[string][]Element{
+		cfg.SetNameAccept: listElements(state.Accept),
+		cfg.SetNameDrop:   listElements(state.Drop),
+	}
+
+	for _, set := range state.Sets {
+		name := set.Name
+		if name == "" {
+			name = cfg.SetName(set.Verdict == VerdictAccept)
+		}
+
+		rv[name] = append(rv[name], set.Elements...)
+	}
+
+	return rv
+}
+
+// listElements возвращает элементы без таймаута и комментария для списка сетей.
+func listElements(networks []string) []Element {
+	rv := make([]Element, len(networks))
+	for i, network := range networks {
+		rv[i] = Element{Network: network}
+	}
+
+	return rv
+}
+
+// Plan возвращает изменения, которые приведут сеты к желаемому состоянию.
+// Сети состояния и сета сравниваются по покрытию адресов: пересекающиеся и смежные сети объединяются,
+// поэтому сет, объединенный при добавлении (AddChange), совпадает с исходным списком сетей.
+// Элементы, которые нужно удалить частично, заменяются оставшимися частями.
+// Сети добавляются с оставшимся временем жизни (expires) и комментарием элементов состояния,
+// а элементы, которые уже есть в сете, не меняются. Сеты, которых нет в состоянии, очищаются.
+// Сначала идут удаления, затем добавления.
+// Добавляемые сети проверяются на пересечения с желаемым содержимым сетов с другим вердиктом, как при AddChange.
+func (fw *Firewall) Plan(state State) ([]config.Change, error) {
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	desired := state.elements(fw.config.Config)
+
+	var (
+		removes, adds []config.Change
+		sets          = make([]Set, len(specs)) // сеты после изменений без добавляемых сетей
+		added         = make(map[string][]string)
+	)
+
+	for i, spec := range specs {
+		wanted := desired[spec.Name]
+		delete(desired, spec.Name)
+
+		networks := make([]string, len(wanted))
+		for j, elem := range wanted {
+			networks[j] = elem.Network
+		}
+
+		want, err := utils.Aggregate(networks)
+		if err != nil {
+			return nil, err
+		}
+
+		elements, err := fw.ListElements(spec.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		ranges, err := elementRanges(elements)
+		if err != nil {
+			return nil, err
+		}
+
+		have := utils.CollapseRanges(ranges)
+
+		if cut := cutElements(config.Change{Set: spec.Name}, elements, ranges, utils.Subtract(have, want)); len(cut) > 0 {
+			removes = append(removes, cut[0])
+			adds = append(adds, cut[1:]...)
+		}
+
+		missing, err := addElements(spec.Name, wanted, have)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, change := range missing {
+			adds = append(adds, change)
+			added[spec.Name] = append(added[spec.Name], change.Networks...)
+		}
+
+		sets[i] = Set{Name: spec.Name, Verdict: spec.Verdict}
+		for _, network := range rangeNetworks(utils.Subtract(want, utils.Subtract(want, have))) {
+			sets[i].Elements = append(sets[i].Elements, Element{Network: network})
+		}
+	}
+
+	if len(desired) > 0 {
+		names := slices.Sorted(maps.Keys(desired))
+
+		return nil, fmt.Errorf("%w: %s", config.ErrUnknownSet, strings.Join(names, ", "))
+	}
+
+	found, err := conflicts(specs, sets, added)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = fw.reportConflicts(found); err != nil {
+		return nil, err
+	}
+
+	return append(removes, adds...), nil
+}
+
+// addElements возвращает добавления адресов элементов, которых нет в have.
+// Сети группируются по таймауту и комментарию, адреса из нескольких групп добавляются с первой из них.
+func addElements(set string, elements []Element, have []utils.IPRange) ([]config.Change, error) {
+	type attrs struct {
+		timeout time.Duration
+		comment string
+	}
+
+	var (
+		order  []attrs // порядок добавлений для воспроизводимости
+		groups = make(map[attrs][]string)
+	)
+
+	for _, elem := range elements {
+		key := attrs{comment: elem.Comment}
+
+		if elem.Expires != "" {
+			timeout, err := time.ParseDuration(elem.Expires)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", elem.Network, err)
+			}
+
+			key.timeout = timeout
+		}
+
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+
+		groups[key] = append(groups[key], elem.Network)
+	}
+
+	var rv []config.Change
+
+	covered := have
+
+	for _, key := range order {
+		ranges, err := utils.Aggregate(groups[key])
+		if err != nil {
+			return nil, err
+		}
+
+		if nets := rangeNetworks(utils.Subtract(ranges, covered)); len(nets) > 0 {
+			rv = append(rv, config.Change{Set: set, Add: true, Networks: nets, Timeout: key.timeout, Comment: key.comment})
+		}
+
+		covered = utils.CollapseRanges(append(slices.Clone(covered), ranges...))
+	}
+
+	return rv, nil
+}
+
+// Apply выполняет изменения одной операцией, если фаервол это поддерживает.
+// В журнал пишется запись для каждого изменения.
+func (fw *Firewall) Apply(changes []config.Change) error {
+	err := fw.handler.Apply(changes)
+	for _, change := range changes {
+		fw.recordChange(change, err)
+	}
+
+	return err
+}
+
+// Replace заменяет содержимое сета сетями изменения одной операцией.
+// Пересекающиеся и смежные сети объединяются, пересечения с сетами с другим вердиктом
+// логируются, а со Strict отменяют замену.
+// Возвращает число добавленных и удаленных диапазонов адресов относительно прежнего покрытия сета,
+// поэтому элементы, разбитые на CIDR (ipset) или объединенные при добавлении, изменениями не считаются.
+func (fw *Firewall) Replace(change config.Change) (int, int, error) {
+	want, err := utils.Aggregate(change.Networks)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	elements, err := fw.ListElements(fw.config.ChangeSet(change))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ranges, err := elementRanges(elements)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	have := utils.CollapseRanges(ranges)
+
+	change.Add = true
+	change.Networks = rangeNetworks(want)
+
+	if err = fw.checkConflicts(change); err != nil {
+		fw.recordSetChange(change, true, err)
+
+		return 0, 0, err
+	}
+
+	err = fw.handler.Replace(change)
+	fw.recordSetChange(change, true, err)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(utils.Subtract(want, have)), len(utils.Subtract(have, want)), nil
+}
+
+// elementRanges возвращает диапазоны адресов элементов сета.
+func elementRanges(elements []config.Element) ([]utils.IPRange, error) {
+	rv := make([]utils.IPRange, len(elements))
+
+	for i, elem := range elements {
+		r, err := utils.ParseRange(elem.Network)
+		if err != nil {
+			return nil, err
+		}
+
+		rv[i] = r
+	}
+
+	return rv, nil
+}
+
+// rangeNetworks возвращает диапазоны в виде сетей, как их возвращает List.
+func rangeNetworks(ranges []utils.IPRange) []string {
+	rv := make([]string, len(ranges))
+	for i, r := range ranges {
+		rv[i] = r.String()
+	}
+
+	return rv
+}
@@ -0,0 +1,180 @@
+package fwset
+
+import (
+	"errors"
+
+	"github.com/LeKovr/fwset/config"
+	"github.com/LeKovr/fwset/utils"
+)
+
+// ErrTxDone возвращается при работе с завершенной транзакцией.
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// Tx накапливает изменения сетов и применяет их при Commit одной операцией Apply.
+// Изменения сверяются с содержимым сетов, как в AddChange и Remove:
+// nftables выполняет все изменения одной транзакцией, ipset подменяет готовыми копиями сеты
+// с добавлениями и удалениями, а остальные меняет напрямую с отменой при ошибке.
+// До Commit фаервол не меняется, поэтому Rollback только отбрасывает изменения.
+type Tx struct {
+	fw      *Firewall
+	changes []config.Change
+	done    bool
+}
+
+// Begin начинает транзакцию.
+func (fw *Firewall) Begin() *Tx {
+	return &Tx{fw: fw}
+}
+
+// Add ставит в очередь добавление сетей в сет.
+func (tx *Tx) Add(set string, networks []string) error {
+	return tx.Queue(config.Change{Set: set, Add: true, Networks: networks})
+}
+
+// Remove ставит в очередь удаление сетей из сета.
+func (tx *Tx) Remove(set string, networks []string) error {
+	return tx.Queue(config.Change{Set: set, Networks: networks})
+}
+
+// Queue проверяет сет и сети изменения и ставит его в очередь.
+func (tx *Tx) Queue(change config.Change) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if _, err := tx.fw.config.SetSpec(tx.fw.config.ChangeSet(change)); err != nil {
+		return err
+	}
+
+	for _, network := range change.Networks {
+		if _, err := utils.ParseRange(network); err != nil {
+			return err
+		}
+	}
+
+	tx.changes = append(tx.changes, change)
+
+	return nil
+}
+
+// Changes возвращает изменения в очереди.
+func (tx *Tx) Changes() []config.Change {
+	return tx.changes
+}
+
+// Commit применяет изменения в очереди и завершает транзакцию.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+
+	if len(tx.changes) == 0 {
+		return nil
+	}
+
+	changes, err := tx.fw.reconcileChanges(tx.changes)
+	if err != nil {
+		for _, change := range tx.changes {
+			tx.fw.recordChange(change, err)
+		}
+
+		return err
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return tx.fw.Apply(changes)
+}
+
+// reconcileChanges возвращает изменения, которые применят changes по очереди, как AddChange и Remove:
+// каждое изменение сверяется с содержимым сетов после предыдущих изменений.
+// Добавляемые сети проверяются на пересечения с сетами с другим вердиктом после всех изменений,
+// поэтому перенос сети из сета accept в сет drop конфликтом не считается.
+func (fw *Firewall) reconcileChanges(changes []config.Change) ([]config.Change, error) {
+	specs, err := fw.config.SetSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	elements := make(map[string][]config.Element, len(specs))
+
+	for _, spec := range specs {
+		if elements[spec.Name], err = fw.ListElements(spec.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		rv    []config.Change
+		added = make(map[string][]string)
+	)
+
+	for _, change := range changes {
+		set := fw.config.ChangeSet(change)
+
+		var reconciled []config.Change
+
+		if change.Add {
+			reconciled, err = reconcileElements(change, elements[set])
+			added[set] = append(added[set], change.Networks...)
+		} else {
+			reconciled, err = removeElements(change, elements[set])
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range reconciled {
+			elements[set] = applyElements(elements[set], c)
+		}
+
+		rv = append(rv, reconciled...)
+	}
+
+	sets := make([]Set, len(specs)) // сеты после изменений без добавляемых сетей
+
+	for i, spec := range specs {
+		ranges, err := elementRanges(elements[spec.Name])
+		if err != nil {
+			return nil, err
+		}
+
+		adds, err := utils.Aggregate(added[spec.Name])
+		if err != nil {
+			return nil, err
+		}
+
+		sets[i] = Set{Name: spec.Name, Verdict: spec.Verdict}
+		for _, network := range rangeNetworks(utils.Subtract(utils.CollapseRanges(ranges), adds)) {
+			sets[i].Elements = append(sets[i].Elements, Element{Network: network})
+		}
+	}
+
+	found, err := conflicts(specs, sets, added)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = fw.reportConflicts(found); err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// Rollback отбрасывает изменения в очереди и завершает транзакцию.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+	tx.changes = nil
+
+	return nil
+}
@@ -16,7 +16,7 @@ import (
 
 func IPRangeToCIDR(cidr []string, start, end string) ([]string, error) {
 	if start == end {
-		return []string{start}, nil
+		return append(cidr, start), nil
 	}
 
 	ips, err := netip.ParseAddr(start)
@@ -78,11 +78,10 @@ func IPRangeToCIDR(cidr []string, start, end string) ([]string, error) {
 		}
 
 		addr, _ := netip.AddrFromSlice(ipsInt.FillBytes(buf))
-		maskStr := strconv.FormatUint(uint64(maxBit-bits), 10)
 		rv := addr.String()
 
-		if maskStr != "32" {
-			rv += "/" + maskStr
+		if bits != 0 { // адрес хоста пишем без маски
+			rv += "/" + strconv.FormatUint(uint64(maxBit-bits), 10)
 		}
 
 		cidr = append(cidr, rv)
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// IPRange описывает непрерывный диапазон адресов одного семейства.
+type IPRange struct {
+	Start netip.Addr
+	End   netip.Addr
+}
+
+// ParseRange возвращает диапазон для адреса, сети или диапазона вида a-b.
+func ParseRange(network string) (IPRange, error) {
+	firstIP, lastIP, err := CIDRToRange(network)
+	if err != nil {
+		return IPRange{}, err
+	}
+
+	start, _ := netip.AddrFromSlice(firstIP)
+	end, _ := netip.AddrFromSlice(lastIP)
+
+	rv := IPRange{Start: start.Unmap(), End: end.Unmap()}
+	if rv.Start.Compare(rv.End) > 0 {
+		return IPRange{}, fmt.Errorf("invalid IP range: start > end")
+	}
+
+	return rv, nil
+}
+
+// String возвращает адрес хоста, сеть, если диапазон ей соответствует, или диапазон вида a-b.
+func (r IPRange) String() string {
+	nets, err := IPRangeToCIDR(nil, r.Start.String(), r.End.String())
+	if err != nil || len(nets) > 1 {
+		// для нас диапазон будет лучше
+		return r.Start.String() + "-" + r.End.String()
+	}
+
+	return nets[0]
+}
+
+// Overlaps сообщает, есть ли у диапазонов общие адреса.
+func (r IPRange) Overlaps(o IPRange) bool {
+	return r.Start.Compare(o.End) <= 0 && o.Start.Compare(r.End) <= 0
+}
+
+// Contains сообщает, входит ли диапазон o в r целиком.
+func (r IPRange) Contains(o IPRange) bool {
+	return r.Start.Compare(o.Start) <= 0 && o.End.Compare(r.End) <= 0
+}
+
+// Aggregate разбирает адреса, сети и диапазоны, удаляет дубликаты и объединяет
+// пересекающиеся и смежные. Диапазоны возвращаются отсортированными.
+func Aggregate(networks []string) ([]IPRange, error) {
+	ranges := make([]IPRange, len(networks))
+
+	for i, network := range networks {
+		r, err := ParseRange(network)
+		if err != nil {
+			return nil, err
+		}
+
+		ranges[i] = r
+	}
+
+	return CollapseRanges(ranges), nil
+}
+
+// CollapseRanges сортирует диапазоны и объединяет пересекающиеся и смежные в один.
+func CollapseRanges(ranges []IPRange) []IPRange {
+	if len(ranges) == 0 {
+		return ranges
+	}
+
+	sorted := make([]IPRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start.Less(sorted[j].Start)
+	})
+
+	rv := []IPRange{sorted[0]}
+
+	for _, r := range sorted[1:] {
+		last := &rv[len(rv)-1]
+		if next := last.End.Next(); last.Overlaps(r) || (next.IsValid() && next == r.Start) {
+			if r.End.Compare(last.End) > 0 {
+				last.End = r.End
+			}
+
+			continue
+		}
+
+		rv = append(rv, r)
+	}
+
+	return rv
+}
+
+// Subtract возвращает части диапазонов ranges, которые не покрыты диапазонами cut.
+func Subtract(ranges, cut []IPRange) []IPRange {
+	cut = CollapseRanges(cut)
+
+	var rv []IPRange
+
+	for _, r := range ranges {
+		rest := true
+
+		for _, c := range cut {
+			if !r.Overlaps(c) {
+				continue
+			}
+
+			if r.Start.Less(c.Start) {
+				rv = append(rv, IPRange{Start: r.Start, End: c.Start.Prev()})
+			}
+
+			if c.End.Compare(r.End) >= 0 {
+				rest = false
+
+				break
+			}
+
+			r.Start = c.End.Next()
+		}
+
+		if rest {
+			rv = append(rv, r)
+		}
+	}
+
+	return rv
+}
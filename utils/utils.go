@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 
@@ -43,6 +45,10 @@ func CIDRToRange(network string) (net.IP, net.IP, error) {
 		if lastIP == nil {
 			return nil, nil, fmt.Errorf("invalid Last IP")
 		}
+
+		if (firstIP.To4() == nil) != (lastIP.To4() == nil) {
+			return nil, nil, fmt.Errorf("IP range family mismatch")
+		}
 	} else {
 		ipnet, err := ParseNetwork(network) // добавим маску, если не было
 		if err != nil {
@@ -130,3 +136,23 @@ func PreviousIP(ip net.IP) net.IP {
 
 	return net.IP(bytes)
 }
+
+// ReadNetworks читает сети по одной в строке.
+// Пустые строки и комментарии (#) пропускаются, текст после первого пробела игнорируется.
+func ReadNetworks(r io.Reader) ([]string, error) {
+	var networks []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		networks = append(networks, strings.TrimRight(fields[0], ",;"))
+	}
+
+	return networks, scanner.Err()
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 
 	ass "github.com/alecthomas/assert/v2"
@@ -165,6 +166,16 @@ func Test_IPRangeToCIDR(t *testing.T) {
 			args: args{startIP: "10.10.2.0", endIP: "10.10.2.16"},
 			want: []string{"10.10.2.0/28", "10.10.2.16"},
 		},
+		{
+			name: "ipv6 /32",
+			args: args{startIP: "2001:db8::", endIP: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+			want: []string{"2001:db8::/32"},
+		},
+		{
+			name: "ipv6 range",
+			args: args{startIP: "2001:db8::", endIP: "2001:db8::2"},
+			want: []string{"2001:db8::/127", "2001:db8::2"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -180,3 +191,74 @@ func Test_IPRangeToCIDR(t *testing.T) {
 		})
 	}
 }
+
+func TestCollapseRanges(t *testing.T) {
+	var ranges []IPRange
+
+	for _, network := range []string{"10.0.0.128/25", "10.0.1.0", "10.0.0.0/25", "10.0.5.0/24", "2001:db8::/33", "2001:db8:8000::/33"} {
+		r, err := ParseRange(network)
+		ass.NoError(t, err)
+
+		ranges = append(ranges, r)
+	}
+
+	var got []string
+	for _, r := range CollapseRanges(ranges) {
+		got = append(got, r.String())
+	}
+
+	ass.Equal(t, []string{"10.0.0.0-10.0.1.0", "10.0.5.0/24", "2001:db8::/32"}, got)
+
+	_, err := ParseRange("10.0.0.9-10.0.0.1")
+	ass.Error(t, err)
+}
+
+func TestAggregate(t *testing.T) {
+	ranges, err := Aggregate([]string{"10.1.2.3", "10.0.0.0/8", "10.1.2.3", "11.0.0.0/8", "192.0.2.10-192.0.2.20",
+		"192.0.2.15-192.0.2.30", "2001:db8::1", "2001:db8::/64"})
+	ass.NoError(t, err)
+
+	var got []string
+	for _, r := range ranges {
+		got = append(got, r.String())
+	}
+
+	ass.Equal(t, []string{"10.0.0.0/7", "192.0.2.10-192.0.2.30", "2001:db8::/64"}, got)
+
+	_, err = Aggregate([]string{"10.0.0.1", "invalid"})
+	ass.Error(t, err)
+
+	// накопление результата для диапазона из одного адреса
+	cidr, err := IPRangeToCIDR([]string{"10.0.0.0/24"}, "10.0.1.1", "10.0.1.1")
+	ass.NoError(t, err)
+	ass.Equal(t, []string{"10.0.0.0/24", "10.0.1.1"}, cidr)
+}
+
+func TestSubtract(t *testing.T) {
+	ranges, err := Aggregate([]string{"10.0.0.0/23", "10.0.5.1", "2001:db8::/32"})
+	ass.NoError(t, err)
+
+	cut, err := Aggregate([]string{"10.0.0.0/24", "10.0.1.7", "10.0.5.1", "2001:db8::/33"})
+	ass.NoError(t, err)
+
+	var got []string
+	for _, r := range Subtract(ranges, cut) {
+		got = append(got, r.String())
+	}
+
+	ass.Equal(t, []string{"10.0.1.0-10.0.1.6", "10.0.1.8-10.0.1.255", "2001:db8:8000::/33"}, got)
+}
+
+func TestReadNetworks(t *testing.T) {
+	input := `# blocklist
+10.0.0.0/8
+  192.168.1.1   # office
+
+172.16.0.0/12 scanners
+2001:db8::/32;
+11.11.13.2-11.11.13.16	tor exits
+`
+	got, err := ReadNetworks(strings.NewReader(input))
+	ass.NoError(t, err)
+	ass.Equal(t, []string{"10.0.0.0/8", "192.168.1.1", "172.16.0.0/12", "2001:db8::/32", "11.11.13.2-11.11.13.16"}, got)
+}